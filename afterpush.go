@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AfterPush - struct for reading info about HTTP request sent after the repo is pushed from input YAML, e.g. triggering
+// CI of the target via its API.
+type AfterPush struct {
+	// Method is HTTP method of the request, POST when not set.
+	Method string `yaml:"method,omitempty"`
+	// Url and Body are templates, see afterPushVars.
+	Url  string `yaml:"url"`
+	Body string `yaml:"body,omitempty"`
+	// Headers are set on the request, with env variables expanded in their values (e.g. tokens).
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// PerBranch sends the request once per updated branch, instead of once per repo with anything updated.
+	PerBranch bool `yaml:"perBranch,omitempty"`
+	// Required fails the repo when the request fails, otherwise the failure is only warned about.
+	Required bool `yaml:"required,omitempty"`
+}
+
+// afterPushVars - Substitute {repo}, {branch} and {branches} placeholders of the template with name of the repo, its
+// updated (target) branch and comma separated ones, escaped by escape (branch names come from the source, so they
+// can't be pasted in as they are).
+func afterPushVars(template string, repoName string, branch string, branches []string, escape func(string) string) string {
+	values := []string{repoName, branch, strings.Join(branches, ",")}
+	for i, v := range values {
+		values[i] = escape(v)
+	}
+
+	return strings.NewReplacer("{repo}", values[0], "{branch}", values[1], "{branches}", values[2]).Replace(template)
+}
+
+// jsonEscape - Escape the value for a JSON string, without the quotes, for placeholders of afterPush bodies.
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+// sendAfterPush - Send the afterPush request, once per repo or per branch, for updated branches and tags of the repo.
+// Nothing is sent when nothing was updated.
+func sendAfterPush(ctx context.Context, rs *Repo, result *RepoResult, logger *log.Entry) error {
+	var branches []string
+	for _, b := range result.Branches {
+		if b.Outcome == outcomeUpdated {
+			branches = append(branches, b.Target)
+		}
+	}
+	tagsUpdated := false
+	for _, t := range result.Tags {
+		tagsUpdated = tagsUpdated || t.Outcome == outcomeUpdated
+	}
+
+	var perRequest []string
+	switch {
+	case rs.AfterPush.PerBranch:
+		perRequest = branches
+	case len(branches) > 0 || tagsUpdated:
+		perRequest = []string{""}
+	}
+
+	var failed []string
+	for _, branch := range perRequest {
+		if err := doAfterPush(ctx, rs.AfterPush, rs.Name, branch, branches, logger); err != nil {
+			logger.Warnf("afterPush request of '%s' failed: %v", rs.Name, err)
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("afterPush request failed: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// doAfterPush - Send single afterPush request, failing on non-2xx response. The request is canceled with ctx, or after
+// apiTimeout.
+func doAfterPush(ctx context.Context, a *AfterPush, repoName string, branch string, branches []string, logger *log.Entry) error {
+	method := a.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	u := afterPushVars(a.Url, repoName, branch, branches, url.PathEscape)
+
+	var body io.Reader
+	if a.Body != "" {
+		body = strings.NewReader(afterPushVars(a.Body, repoName, branch, branches, jsonEscape))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range a.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+
+	logger.Infof("Sending afterPush request %s %s", method, u)
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
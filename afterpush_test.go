@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAfterPushVars(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		branch   string
+		escape   func(string) string
+		want     string
+	}{
+		{"url", "https://ci/{repo}/{branch}", "feature/a b", url.PathEscape, "https://ci/foo/feature%2Fa%20b"},
+		{"json body", `{"ref": "{branch}", "all": "{branches}"}`, "main", jsonEscape, `{"ref": "main", "all": "main,dev"}`},
+		{"json body with quote", `{"ref": "{branch}"}`, `x", "admin": "true`, jsonEscape, `{"ref": "x\", \"admin\": \"true"}`},
+		{"json body with backslash", `{"ref": "{branch}"}`, `a\b`, jsonEscape, `{"ref": "a\\b"}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := afterPushVars(c.template, "foo", c.branch, []string{c.branch, "dev"}, c.escape)
+			if got != c.want {
+				t.Errorf("expected %s, got %s", c.want, got)
+			}
+		})
+	}
+}
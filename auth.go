@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenRefreshMargin - Installation tokens expiring sooner than this are minted anew before use.
+const tokenRefreshMargin = 5 * time.Minute
+
+// GithubApp - struct for reading GitHub App installation credentials from input YAML.
+type GithubApp struct {
+	AppId          int64  `yaml:"appId"`
+	InstallationId int64  `yaml:"installationId"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	ApiUrl         string `yaml:"apiUrl,omitempty"`
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// auth - Return auth method for operations with the remote, nil when remote has no credentials or HTTP headers
+// configured.
+func (r *Remote) auth() (transport.AuthMethod, error) {
+	base, err := r.credentials()
+	if err != nil || len(r.HttpHeaders) == 0 {
+		return base, err
+	}
+
+	return &headerAuth{headers: r.HttpHeaders, next: base}, nil
+}
+
+// credentials - Return auth method of the remote's credentials, nil when it has none.
+func (r *Remote) credentials() (githttp.AuthMethod, error) {
+	if r.GithubApp == nil {
+		if r.token == "" {
+			return nil, nil
+		}
+		username := r.Username
+		if username == "" {
+			// Hosting services authenticate by the token, username only needs to be non-empty.
+			username = "git"
+		}
+		return &githttp.BasicAuth{Username: username, Password: r.token}, nil
+	}
+
+	if _, err := r.GithubApp.privateKey(); err != nil {
+		return nil, err
+	}
+
+	return &githubAppAuth{app: r.GithubApp}, nil
+}
+
+// readToken - Read secret token from tokenFile (e.g. mounted Docker or Kubernetes secret) with trailing whitespace
+// trimmed or, when there's no file, from tokenEnv env variable.
+func readToken(tokenFile, tokenEnv string) (string, error) {
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token file: %v", err)
+		}
+		token := strings.TrimRight(string(data), " \t\r\n")
+		if token == "" {
+			return "", fmt.Errorf("token file '%s' is empty", tokenFile)
+		}
+		return token, nil
+	}
+
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("no token in env variable '%s'", tokenEnv)
+	}
+
+	return token, nil
+}
+
+// privateKey - Read and parse private key of the app (PKCS#1 as generated by GitHub, or PKCS#8).
+func (a *GithubApp) privateKey() (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(a.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in GitHub App private key '%s'", a.PrivateKeyFile)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key '%s': %v", a.PrivateKeyFile, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key '%s' isn't an RSA key", a.PrivateKeyFile)
+	}
+
+	return rsaKey, nil
+}
+
+// jwt - Return JSON Web Token authenticating as the app, valid for 9 minutes.
+func (a *GithubApp) jwt() (string, error) {
+	key, err := a.privateKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		// Backdated to allow for clock drift, see GitHub Apps docs.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.AppId,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// installationToken - Return installation access token of the app, minting a new one when there's none yet or it's
+// about to expire.
+func (a *GithubApp) installationToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Until(a.expires) > tokenRefreshMargin {
+		return a.token, nil
+	}
+
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", err
+	}
+
+	apiUrl := a.ApiUrl
+	if apiUrl == "" {
+		apiUrl = "https://api.github.com"
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", strings.TrimSuffix(apiUrl, "/"), a.InstallationId)
+	headers := map[string]string{
+		"Authorization": "Bearer " + jwt,
+		"Accept":        "application/vnd.github+json",
+	}
+
+	var resp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := doJSON(context.Background(), http.MethodPost, url, headers, nil, &resp); err != nil {
+		return "", fmt.Errorf("failed to create GitHub App installation token: %v", err)
+	}
+
+	log.Infof("Created GitHub App installation token for installation %d, expiring at %s",
+		a.InstallationId, resp.ExpiresAt.Format(time.RFC3339))
+	a.token = resp.Token
+	a.expires = resp.ExpiresAt
+
+	return a.token, nil
+}
+
+// githubAppAuth - HTTP auth method using GitHub App installation token as password, refreshing it when needed.
+type githubAppAuth struct {
+	app *GithubApp
+}
+
+func (a *githubAppAuth) Name() string {
+	return "github-app"
+}
+
+func (a *githubAppAuth) String() string {
+	return fmt.Sprintf("%s - app %d, installation %d", a.Name(), a.app.AppId, a.app.InstallationId)
+}
+
+func (a *githubAppAuth) SetAuth(r *http.Request) {
+	token, err := a.app.installationToken()
+	if err != nil {
+		// Request goes out unauthenticated and fails with auth error.
+		log.Errorf("%v", err)
+		return
+	}
+
+	r.SetBasicAuth("x-access-token", token)
+}
+
+// headerAuth - HTTP auth method setting custom headers (e.g. of SSO proxies) on requests, on top of credentials of the
+// remote if it has any. Header values (with env variables expanded) are never printed.
+type headerAuth struct {
+	headers map[string]string
+	next    githttp.AuthMethod
+}
+
+func (a *headerAuth) Name() string {
+	return "http-headers"
+}
+
+func (a *headerAuth) String() string {
+	names := make([]string, 0, len(a.headers))
+	for k := range a.headers {
+		names = append(names, fmt.Sprintf("%s: <redacted>", k))
+	}
+	sort.Strings(names)
+
+	s := fmt.Sprintf("%s - %s", a.Name(), strings.Join(names, ", "))
+	if a.next != nil {
+		s += ", " + a.next.String()
+	}
+	return s
+}
+
+func (a *headerAuth) SetAuth(r *http.Request) {
+	for k, v := range a.headers {
+		r.Header.Set(k, os.ExpandEnv(v))
+	}
+	if a.next != nil {
+		a.next.SetAuth(r)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	formatgraph "github.com/go-git/go-git/v5/plumbing/format/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+	log "github.com/sirupsen/logrus"
+)
+
+// writeCommitGraph - Write commit-graph file of all reachable commits of the repo at path with `git commit-graph
+// write`, go-git can't write one. Skipped with a warning when git isn't installed or fails, syncing works without it.
+func writeCommitGraph(path string, logger *log.Entry) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		logger.Warnf("Not writing commit-graph of %s, git isn't installed: %v", path, err)
+		return
+	}
+
+	logger.Infof("Writing commit-graph of %s", path)
+	out, err := exec.Command(gitBin, "-C", path, "commit-graph", "write", "--reachable").CombinedOutput()
+	if err != nil {
+		logger.Warnf("failed to write commit-graph of %s: %v: %s", path, err, strings.TrimSpace(string(out)))
+	}
+}
+
+// commitNodes - Index of commits of the repo, backed by its commit-graph file when it has one (so walking ancestry
+// reads no commit objects and can use generation numbers) and by commit objects otherwise. Returned closer releases
+// the commit-graph file.
+func commitNodes(repo *git.Repository) (commitgraph.CommitNodeIndex, io.Closer) {
+	objects := commitgraph.NewObjectCommitNodeIndex(repo.Storer)
+	storage, ok := repo.Storer.(dotGitStorage)
+	if !ok {
+		return objects, io.NopCloser(nil)
+	}
+
+	fs := storage.Filesystem()
+	f, err := os.Open(fs.Join(fs.Root(), "objects", "info", "commit-graph"))
+	if err != nil {
+		return objects, io.NopCloser(nil)
+	}
+	index, err := formatgraph.OpenFileIndex(f)
+	if err != nil {
+		f.Close()
+		return objects, io.NopCloser(nil)
+	}
+
+	return commitgraph.NewGraphCommitNodeIndex(index, repo.Storer), f
+}
+
+// isAncestorNode - Whether commit ancestor is reachable from commit of, walking parents. With generation numbers of
+// the commit-graph, commits of generation not above ancestor's can't reach it and aren't walked further.
+func isAncestorNode(ancestor, of commitgraph.CommitNode) (bool, error) {
+	generation := ancestor.Generation()
+	// Commits missing in the commit-graph have infinite generation, ones written by old git zero (not computed).
+	pruned := generation != 0 && generation != math.MaxUint64
+
+	seen := map[plumbing.Hash]bool{}
+	stack := []commitgraph.CommitNode{of}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.ID() == ancestor.ID() {
+			return true, nil
+		}
+		if seen[n.ID()] {
+			continue
+		}
+		seen[n.ID()] = true
+		if g := n.Generation(); pruned && g != 0 && g <= generation {
+			continue
+		}
+
+		err := n.ParentNodes().ForEach(func(p commitgraph.CommitNode) error {
+			stack = append(stack, p)
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// targetDiverged - Whether the target tip isn't an ancestor of the tip about to be pushed over it, so force pushing
+// would drop commits pushed to the target directly. Target tips missing in the repo (target not fetched, or pushed to
+// meanwhile) count as diverged, there's no telling what they contain.
+func targetDiverged(repo *git.Repository, target, tip plumbing.Hash) (bool, error) {
+	if target == tip {
+		return false, nil
+	}
+
+	nodes, closer := commitNodes(repo)
+	defer closer.Close()
+
+	targetNode, err := nodes.Get(target)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	tipNode, err := nodes.Get(tip)
+	if err != nil {
+		return false, err
+	}
+
+	ancestor, err := isAncestorNode(targetNode, tipNode)
+	return !ancestor, err
+}
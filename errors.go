@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	httptransport "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// Categories of errors, for callers to distinguish them with errors.Is.
+var (
+	ErrConfigInvalid = errors.New("invalid config")
+	ErrAuth          = errors.New("authentication failed")
+	ErrNetwork       = errors.New("network error")
+	ErrPushRejected  = errors.New("push rejected")
+	ErrInterrupted   = errors.New("interrupted")
+)
+
+// Exit codes of the CLI for respective error categories, 1 is used for any other failure.
+const (
+	exitFailure      = 1
+	exitUsage        = 2
+	exitConfig       = 3
+	exitAuth         = 4
+	exitNetwork      = 5
+	exitPushRejected = 6
+	// exitDrift is returned by --diff-only when targets differ from sources.
+	exitDrift = 7
+	// exitInterrupted follows the shell convention of 128 + SIGINT.
+	exitInterrupted = 130
+)
+
+// SyncError - failure syncing a repository. Besides its cause it matches (errors.Is) the category of the cause, if
+// there's one.
+type SyncError struct {
+	Repo string
+	Kind error
+	Err  error
+}
+
+// syncError - Wrap error of syncing the repository, categorizing it.
+func syncError(repo *Repo, err error) *SyncError {
+	return &SyncError{Repo: repo.Name, Kind: errorKind(err), Err: err}
+}
+
+func (e *SyncError) Error() string {
+	return fmt.Sprintf("repo '%s': %v", e.Repo, e.Err)
+}
+
+func (e *SyncError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SyncError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// errorKind - Return category (one of sentinel errors above) of the error, nil if it doesn't fall into any.
+func errorKind(err error) error {
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrConfigInvalid):
+		return ErrConfigInvalid
+	case errors.Is(err, context.Canceled):
+		return ErrInterrupted
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return ErrAuth
+	case errors.Is(err, git.ErrNonFastForwardUpdate), errors.Is(err, git.ErrForceNeeded),
+		strings.Contains(err.Error(), "command error on "), strings.Contains(err.Error(), "unpack error: "):
+		return ErrPushRejected
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.As(err, &netErr):
+		return ErrNetwork
+	}
+
+	return nil
+}
+
+// isRetryable - Whether the failed remote operation may succeed when attempted again: dial and other timeouts, EOF,
+// connection reset, temporary network errors and server side (5xx, 429) HTTP errors are, authentication failures,
+// rejected pushes, missing repositories and any other errors aren't.
+func isRetryable(err error) bool {
+	err = unwrapClientError(err)
+	if err == nil {
+		return false
+	}
+
+	switch errorKind(err) {
+	case ErrAuth, ErrPushRejected, ErrConfigInvalid, ErrInterrupted:
+		return false
+	}
+
+	var netErr net.Error
+	var httpErr *httptransport.Err
+	switch {
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.As(err, &netErr):
+		// Temporary is deprecated, but still the only mark of e.g. temporary DNS failures.
+		return netErr.Timeout() || netErr.Temporary()
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode() >= http.StatusInternalServerError ||
+			httpErr.StatusCode() == http.StatusTooManyRequests
+	}
+
+	// Errors of ssh connections and of reading the pack are often only formatted, not wrapped.
+	msg := err.Error()
+	return strings.HasSuffix(msg, ": EOF") || strings.Contains(msg, "connection reset by peer") ||
+		strings.Contains(msg, "unexpected EOF")
+}
+
+// unwrapClientError - Unwrap go-git client errors, which don't support errors.Unwrap, down to their cause.
+func unwrapClientError(err error) error {
+	var unexpected *plumbing.UnexpectedError
+	var permanent *plumbing.PermanentError
+	for {
+		switch {
+		case errors.As(err, &unexpected):
+			err = unexpected.Err
+		case errors.As(err, &permanent):
+			err = permanent.Err
+		default:
+			return err
+		}
+	}
+}
+
+// exitCode - Return exit code of the CLI for the error.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrConfigInvalid):
+		return exitConfig
+	case errors.Is(err, ErrAuth):
+		return exitAuth
+	case errors.Is(err, ErrNetwork):
+		return exitNetwork
+	case errors.Is(err, ErrPushRejected):
+		return exitPushRejected
+	case errors.Is(err, ErrInterrupted):
+		return exitInterrupted
+	}
+
+	return exitFailure
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestIsRetryable(t *testing.T) {
+	status := func(code int) error {
+		return plumbing.NewUnexpectedError(&githttp.Err{Response: &http.Response{
+			StatusCode: code,
+			Request:    &http.Request{URL: &url.URL{Scheme: "https", Host: "example.com"}},
+		}})
+	}
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("fetch: %w", io.EOF), true},
+		{plumbing.NewUnexpectedError(io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{&net.DNSError{Err: "lookup failed", IsTimeout: true}, true},
+		{&net.DNSError{Err: "lookup failed", IsTemporary: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{context.DeadlineExceeded, true},
+		{status(http.StatusBadGateway), true},
+		{status(http.StatusTooManyRequests), true},
+		{status(http.StatusBadRequest), false},
+		{fmt.Errorf("push: %w", transport.ErrAuthenticationRequired), false},
+		{transport.ErrAuthorizationFailed, false},
+		{transport.ErrRepositoryNotFound, false},
+		{git.ErrNonFastForwardUpdate, false},
+		{fmt.Errorf("command error on refs/heads/main: non-fast-forward"), false},
+		{context.Canceled, false},
+	}
+
+	for _, c := range cases {
+		if got := isRetryable(c.err); got != c.want {
+			t.Errorf("%v: expected retryable %t, got %t", c.err, c.want, got)
+		}
+	}
+}
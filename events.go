@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	log "github.com/sirupsen/logrus"
+)
+
+// Events - struct for reading info about the broker sync events are published to from input YAML.
+type Events struct {
+	// Url of the NATS server, `nats://[user:password@|token@]host[:port]`, `tls://` for TLS connections.
+	Url string `yaml:"url"`
+	// Topic is the subject events are published on.
+	Topic string `yaml:"topic"`
+}
+
+// eventsTimeout - Limit of connecting to the broker and publishing all events of a repo.
+const eventsTimeout = 30 * time.Second
+
+// RefEvent - message published for every ref updated (or deleted) on a target.
+type RefEvent struct {
+	Repo      string `json:"repo"`
+	Ref       string `json:"ref"`
+	OldHash   string `json:"oldHash,omitempty"`
+	NewHash   string `json:"newHash,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// validate - Problems of the events config.
+func (e *Events) validate() []string {
+	var problems []string
+	u, err := url.Parse(e.Url)
+	switch {
+	case e.Url == "":
+		problems = append(problems, "events: missing url")
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("events: invalid url: %v", err))
+	case u.Scheme != "nats" && u.Scheme != "tls":
+		problems = append(problems, fmt.Sprintf("events: unsupported url scheme '%s', only NATS (nats://, tls://) is supported", u.Scheme))
+	}
+	if e.Topic == "" || strings.ContainsAny(e.Topic, " \t\r\n") {
+		problems = append(problems, "events: topic must be set and can't contain whitespace")
+	}
+
+	return problems
+}
+
+// refEvents - Events of branches and tags the sync updated or deleted on the target.
+func refEvents(rs *Repo, result *RepoResult, now time.Time) []RefEvent {
+	timestamp := now.UTC().Format(time.RFC3339)
+	var events []RefEvent
+	for _, b := range result.Branches {
+		if b.Outcome != outcomeUpdated {
+			continue
+		}
+		events = append(events, RefEvent{
+			Repo:      rs.Name,
+			Ref:       rs.targetRef(plumbing.NewBranchReferenceName(b.Target)).String(),
+			OldHash:   b.OldHash,
+			NewHash:   b.Hash,
+			Timestamp: timestamp,
+		})
+	}
+	for _, t := range result.Tags {
+		event := RefEvent{Repo: rs.Name, Ref: rs.targetRef(plumbing.NewTagReferenceName(t.Tag)).String(), Timestamp: timestamp}
+		switch t.Outcome {
+		case outcomeUpdated:
+			event.OldHash, event.NewHash = t.OldHash, t.Hash
+		case outcomeDeleted:
+			event.OldHash = t.Hash
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// publishEvents - Publish events of refs the sync of the repo updated. Failures are only logged, they don't fail the
+// repo.
+func publishEvents(ctx context.Context, e *Events, rs *Repo, result *RepoResult, logger *log.Entry) {
+	events := refEvents(rs, result, time.Now())
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, eventsTimeout)
+	defer cancel()
+	if err := publishNats(ctx, e, events); err != nil {
+		logger.Warnf("failed to publish %d events of '%s' on %s: %v", len(events), rs.Name, e.Topic, err)
+		return
+	}
+	logger.Infof("Published %d events of '%s' on %s", len(events), rs.Name, e.Topic)
+}
+
+// publishNats - Publish the events on a new connection to the NATS server, speaking its text protocol: CONNECT, PUB of
+// each event and PING, waiting for the PONG confirming the server processed all of it (or -ERR it rejected it with).
+func publishNats(ctx context.Context, e *Events, events []RefEvent) error {
+	u, err := url.Parse(e.Url)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	// Server greets with its INFO first.
+	if _, err := readNatsLine(r); err != nil {
+		return err
+	}
+	if u.Scheme == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn, r = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "go-repo-sync", "lang": "go"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			options["user"], options["pass"] = u.User.Username(), pass
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "PUB %s %d\r\n%s\r\n", e.Topic, len(payload), payload)
+	}
+	fmt.Fprint(w, "PING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := readNatsLine(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			fmt.Fprint(w, "PONG\r\n")
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readNatsLine - Read protocol line of the NATS server, without the line ending.
+func readNatsLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
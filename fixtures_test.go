@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/memory"
+	log "github.com/sirupsen/logrus"
+)
+
+// memProtocol - URL scheme of in-memory remotes served to go-git in tests.
+const memProtocol = "mem"
+
+// memRemotes - in-memory repositories served under mem://<name> URLs, keyed by URL.
+var memRemotes = server.MapLoader{}
+
+// memCredentials - `username:password` basic auth in-memory remotes require, keyed by their names. Remotes missing
+// here accept any credentials.
+var memCredentials = map[string]string{}
+
+// memPushCredentials - `username:password` basic auth in-memory remotes require for pushes (receive-pack sessions)
+// instead of memCredentials, keyed by their names.
+var memPushCredentials = map[string]string{}
+
+func TestMain(m *testing.M) {
+	client.InstallProtocol(memProtocol, credentialCheck{next: server.NewClient(memRemotes)})
+	os.Exit(m.Run())
+}
+
+// credentialCheck - transport rejecting sessions with in-memory remotes not authenticated by their memCredentials.
+type credentialCheck struct {
+	next transport.Transport
+}
+
+func (c credentialCheck) check(credentials map[string]string, ep *transport.Endpoint, auth transport.AuthMethod) error {
+	want, ok := credentials[ep.Host]
+	if !ok {
+		return nil
+	}
+	if basic, _ := auth.(*githttp.BasicAuth); basic == nil || basic.Username+":"+basic.Password != want {
+		return transport.ErrAuthorizationFailed
+	}
+	return nil
+}
+
+func (c credentialCheck) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	if err := c.check(memCredentials, ep, auth); err != nil {
+		return nil, err
+	}
+	return c.next.NewUploadPackSession(ep, auth)
+}
+
+func (c credentialCheck) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	credentials := memCredentials
+	if _, ok := memPushCredentials[ep.Host]; ok {
+		credentials = memPushCredentials
+	}
+	if err := c.check(credentials, ep, auth); err != nil {
+		return nil, err
+	}
+	return c.next.NewReceivePackSession(ep, auth)
+}
+
+// addMemRemote - Serve the storage as in-memory remote of given name for the rest of the test, returning its URL.
+// Non-empty credentials (`username:password`) are required by the remote.
+func addMemRemote(t *testing.T, name string, s storer.Storer, credentials string) string {
+	t.Helper()
+
+	url := fmt.Sprintf("%s://%s", memProtocol, name)
+	memRemotes[url] = s
+	if credentials != "" {
+		memCredentials[name] = credentials
+	}
+	t.Cleanup(func() {
+		delete(memRemotes, url)
+		delete(memCredentials, name)
+		delete(memPushCredentials, name)
+	})
+
+	return url
+}
+
+// requirePushCredentials - Require credentials (`username:password`) for pushes to the in-memory remote of given name,
+// added by addMemRemote, instead of those it requires for fetching.
+func requirePushCredentials(name, credentials string) {
+	memPushCredentials[name] = credentials
+}
+
+// testSignature - Author and tagger of commits and tags created by tests.
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "go-repo-sync", Email: "test@localhost", When: time.Now()}
+}
+
+// seedSource - Create in-memory source repo with `master` and `develop` branches, lightweight tag `v1.0.0` on master
+// and annotated tag `v1.1.0` on develop.
+func seedSource(t *testing.T) *git.Repository {
+	t.Helper()
+
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := func(file string) plumbing.Hash {
+		f, err := w.Filesystem.Create(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(file + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Add(file); err != nil {
+			t.Fatal(err)
+		}
+		h, err := w.Commit("Add "+file, &git.CommitOptions{Author: testSignature()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	master := commit("README")
+	if _, err := repo.CreateTag("v1.0.0", master, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("develop"), Create: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	develop := commit("CHANGELOG")
+	_, err = repo.CreateTag("v1.1.0", develop, &git.CreateTagOptions{Tagger: testSignature(), Message: "v1.1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+// emptyTarget - Create empty in-memory bare target repo.
+func emptyTarget(t *testing.T) *git.Repository {
+	t.Helper()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// testRun - Options of a single sync run of tests.
+func testRun() *runOptions {
+	return &runOptions{gcEvery: 1, parallel: 1}
+}
+
+// testLogger - Logger of repos synced by tests.
+func testLogger() *log.Entry {
+	return log.NewEntry(log.StandardLogger())
+}
+
+// expectRefs - Check that the repo has exactly the wanted branches and tags, pointing at wanted hashes.
+func expectRefs(t *testing.T, repo *git.Repository, want map[plumbing.ReferenceName]plumbing.Hash) {
+	t.Helper()
+
+	refs, err := repo.References()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[plumbing.ReferenceName]plumbing.Hash{}
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		if r.Type() == plumbing.HashReference && (r.Name().IsBranch() || r.Name().IsTag()) {
+			got[r.Name()] = r.Hash()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, hash := range want {
+		if got[name] != hash {
+			t.Errorf("expected %s at %s, got %s", name, hash, got[name])
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("unexpected ref %s", name)
+		}
+	}
+}
+
+// refHash - Return hash of the ref in the repo, zero hash when missing.
+func refHash(repo *git.Repository, name plumbing.ReferenceName) plumbing.Hash {
+	r, err := repo.Reference(name, false)
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	return r.Hash()
+}
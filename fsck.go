@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// errCorruptObjects - local object store of the repo is damaged, pushing from it could propagate bad objects.
+var errCorruptObjects = errors.New("corrupt object store")
+
+// fsckRepo - Verify integrity of the repo's objects like a light `git fsck`: every object stored (loose or packed)
+// must be readable and hash to its id, and everything reachable from the tips (commits, their trees and blobs) must be
+// present. Returns number of objects verified, and errCorruptObjects (with the first problem found) on damage.
+func fsckRepo(repo *git.Repository, tips []plumbing.Hash) (int, error) {
+	// Loose objects are read by their id, their content gets hashed on read so iterating them would check nothing.
+	loose := map[plumbing.Hash]bool{}
+	if s, ok := repo.Storer.(storer.LooseObjectStorer); ok {
+		err := s.ForEachObjectHash(func(h plumbing.Hash) error {
+			o, err := repo.Storer.EncodedObject(plumbing.AnyObject, h)
+			if err != nil {
+				return fmt.Errorf("failed to read object %s: %v", h, err)
+			}
+			loose[h] = true
+			return verifyObjectHash(o, h)
+		})
+		if err != nil {
+			return len(loose), fmt.Errorf("%w: %v", errCorruptObjects, err)
+		}
+	}
+
+	objects, err := repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return len(loose), fmt.Errorf("failed to list objects: %w", err)
+	}
+	verified := len(loose)
+	err = objects.ForEach(func(o plumbing.EncodedObject) error {
+		if loose[o.Hash()] {
+			return nil
+		}
+		verified++
+		return verifyObjectHash(o, o.Hash())
+	})
+	if err != nil {
+		return verified, fmt.Errorf("%w: %v", errCorruptObjects, err)
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	for _, tip := range tips {
+		if err := checkReachable(repo, tip, seen); err != nil {
+			return verified, fmt.Errorf("%w: %v", errCorruptObjects, err)
+		}
+	}
+
+	return verified, nil
+}
+
+// verifyObjectHash - Read the object, checking its content hashes to the id it's stored under.
+func verifyObjectHash(o plumbing.EncodedObject, id plumbing.Hash) error {
+	r, err := o.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %v", id, err)
+	}
+	defer r.Close()
+
+	hasher := plumbing.NewHasher(o.Type(), o.Size())
+	if _, err := io.Copy(hasher, r); err != nil {
+		return fmt.Errorf("failed to read object %s: %v", id, err)
+	}
+	if sum := hasher.Sum(); sum != id {
+		return fmt.Errorf("%s %s hashes to %s", o.Type(), id, sum)
+	}
+	return nil
+}
+
+// checkReachable - Check that history of the commit, with trees and blobs of each of its commits, is complete. Objects
+// in seen were already checked.
+func checkReachable(repo *git.Repository, tip plumbing.Hash, seen map[plumbing.Hash]bool) error {
+	pending := []plumbing.Hash{tip}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+
+		commit, err := object.GetCommit(repo.Storer, h)
+		if err != nil {
+			return fmt.Errorf("commit %s: %v", h, err)
+		}
+		if err := checkTree(repo.Storer, commit.TreeHash, seen); err != nil {
+			return fmt.Errorf("tree of commit %s: %v", h, err)
+		}
+		pending = append(pending, commit.ParentHashes...)
+	}
+	return nil
+}
+
+// checkTree - Check that the tree and all its subtrees and blobs are present, skipping submodule entries.
+func checkTree(s storer.EncodedObjectStorer, h plumbing.Hash, seen map[plumbing.Hash]bool) error {
+	if seen[h] {
+		return nil
+	}
+	seen[h] = true
+
+	tree, err := object.GetTree(s, h)
+	if err != nil {
+		return fmt.Errorf("tree %s: %v", h, err)
+	}
+	for _, e := range tree.Entries {
+		switch {
+		case e.Mode == filemode.Submodule || seen[e.Hash]:
+		case e.Mode == filemode.Dir:
+			if err := checkTree(s, e.Hash, seen); err != nil {
+				return err
+			}
+		default:
+			if err := s.HasEncodedObject(e.Hash); err != nil {
+				return fmt.Errorf("blob %s (%s): %v", e.Hash, e.Name, err)
+			}
+			seen[e.Hash] = true
+		}
+	}
+	return nil
+}
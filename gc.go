@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// gcGracePeriod - Unreachable objects younger than this are kept by gc, they may belong to a running git command.
+const gcGracePeriod = time.Hour
+
+// gcRepo - Prune unreachable loose objects of the repo and repack all reachable ones into a single packfile, dropping
+// objects left dangling by force-fetches. Loose objects and packs younger than gcGracePeriod are kept.
+func gcRepo(rs *Repo, logger *log.Entry) error {
+	repo, err := git.PlainOpen(rs.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open repo from %s: %w", rs.Path, err)
+	}
+
+	logger.Infof("Running gc in %s", rs.Path)
+	cutoff := time.Now().Add(-gcGracePeriod)
+	err = repo.Prune(git.PruneOptions{
+		OnlyObjectsOlderThan: cutoff,
+		Handler:              repo.DeleteObject,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune objects in %s: %w", rs.Path, err)
+	}
+
+	if err := repo.RepackObjects(&git.RepackConfig{OnlyDeletePacksOlderThan: cutoff}); err != nil {
+		return fmt.Errorf("failed to repack objects in %s: %w", rs.Path, err)
+	}
+
+	return nil
+}
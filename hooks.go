@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// runHook - Run the shell command with env variables added to the process environment, later ones taking precedence.
+// Output of the command is logged, its failure only warned about - it doesn't change the outcome of the run.
+func runHook(logger *log.Entry, what, command string, env []string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logger.Infof("%s output: %s", what, strings.TrimRight(string(out), "\n"))
+	}
+	if err != nil {
+		logger.Warnf("%s command failed: %v", what, err)
+	}
+}
+
+// runPostSync - Run postSync command of the successfully synced repo. Besides env of the repo it gets name of the repo
+// in REPO_SYNC_REPO and comma separated (target) names of updated branches and tags in REPO_SYNC_UPDATED_BRANCHES and
+// REPO_SYNC_UPDATED_TAGS, taking precedence over env.
+func runPostSync(rs *Repo, result *RepoResult, logger *log.Entry) {
+	var branches, tags []string
+	for _, b := range result.Branches {
+		if b.Outcome == outcomeUpdated {
+			branches = append(branches, b.Target)
+		}
+	}
+	for _, t := range result.Tags {
+		if t.Outcome == outcomeUpdated {
+			tags = append(tags, t.Tag)
+		}
+	}
+
+	keys := make([]string, 0, len(rs.Env))
+	for k := range rs.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var env []string
+	if rs.SkipLFS {
+		env = append(env, lfsSkipSmudgeEnv)
+	}
+	for _, k := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", k, rs.Env[k]))
+	}
+	env = append(env,
+		fmt.Sprintf("REPO_SYNC_REPO=%s", rs.Name),
+		fmt.Sprintf("REPO_SYNC_UPDATED_BRANCHES=%s", strings.Join(branches, ",")),
+		fmt.Sprintf("REPO_SYNC_UPDATED_TAGS=%s", strings.Join(tags, ",")),
+	)
+
+	logger.Infof("Running postSync command of '%s'", rs.Name)
+	runHook(logger, "postSync", rs.PostSync, env)
+}
+
+// runOnError - Run the shell command when any of the repos failed to sync, passing number and comma separated names of
+// the failed repos in REPO_SYNC_FAILED_COUNT and REPO_SYNC_FAILED_REPOS env variables.
+func runOnError(command string, results []*RepoResult) {
+	var failed []string
+	for _, r := range results {
+		if r.Error != "" {
+			failed = append(failed, r.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	log.Infof("Running onError command for %d failed repos", len(failed))
+	runHook(log.NewEntry(log.StandardLogger()), "onError", command, []string{
+		fmt.Sprintf("REPO_SYNC_FAILED_COUNT=%d", len(failed)),
+		fmt.Sprintf("REPO_SYNC_FAILED_REPOS=%s", strings.Join(failed, ",")),
+	})
+}
+
+// copyTargetHooks - Copy hook scripts into hooks directory of the target repo when it's local, named as the scripts
+// (e.g. `pre-receive`) and made executable. Scripts already in place with the same content are left alone. Returns
+// false when the target isn't local.
+func copyTargetHooks(remote *git.Remote, hooks []string, logger *log.Entry) (bool, error) {
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return false, err
+	}
+	if endpoint.Protocol != "file" {
+		return false, nil
+	}
+
+	remoteRepo, err := git.PlainOpen(endpoint.Path)
+	if err != nil {
+		return false, err
+	}
+	storage, ok := remoteRepo.Storer.(*filesystem.Storage)
+	if !ok {
+		return false, nil
+	}
+	hooksDir := filepath.Join(storage.Filesystem().Root(), "hooks")
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return true, err
+	}
+
+	for _, hook := range hooks {
+		content, err := os.ReadFile(hook)
+		if err != nil {
+			return true, fmt.Errorf("failed to read hook: %w", err)
+		}
+		dst := filepath.Join(hooksDir, filepath.Base(hook))
+		if current, err := os.ReadFile(dst); err == nil && bytes.Equal(current, content) {
+			continue
+		}
+
+		logger.Infof("Installing hook %s into %s", filepath.Base(hook), hooksDir)
+		if err := os.WriteFile(dst, content, 0755); err != nil {
+			return true, fmt.Errorf("failed to write hook: %w", err)
+		}
+		// WriteFile keeps mode of existing files.
+		if err := os.Chmod(dst, 0755); err != nil {
+			return true, fmt.Errorf("failed to make hook executable: %w", err)
+		}
+	}
+
+	return true, nil
+}
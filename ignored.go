@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Handling of ignored files checking out a branch would remove or overwrite, see Repo.IgnoredFiles.
+const (
+	ignoredFilesOverwrite = "overwrite"
+	ignoredFilesWarn      = "warn"
+	ignoredFilesAbort     = "abort"
+)
+
+// ignoredFiles - Effective handling of ignored files checking out branches of the repo would remove or overwrite.
+func (r *Repo) ignoredFiles() string {
+	if r.IgnoredFiles == "" {
+		return ignoredFilesOverwrite
+	}
+	return r.IgnoredFiles
+}
+
+// clobberedIgnoredFiles - Untracked files of the worktree ignored by git, which checking out the commit would remove
+// or overwrite. go-git leaves alone only files ignored by .gitignore files (and .git/info/exclude) in the worktree at
+// the time, and checkouts are followed by resets to the tip, so it keeps just files ignored both by .gitignore files
+// currently in the worktree and by those of the commit. Others ignored, e.g. build artifacts ignored only on the branch
+// checked out before, or only by core.excludesFile, are removed.
+func clobberedIgnoredFiles(repo *git.Repository, w *git.Worktree, commit plumbing.Hash) ([]string, error) {
+	current, err := gitignore.ReadPatterns(w.Filesystem, nil)
+	if err != nil {
+		return nil, err
+	}
+	current = append(current, w.Excludes...)
+
+	top := w.Filesystem.Root()
+	exclude, err := os.ReadFile(filepath.Join(top, ".git", "info", "exclude"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	next := parseIgnorePatterns(exclude, nil)
+	c, err := repo.CommitObject(commit)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	committed, err := treeIgnorePatterns(tree)
+	if err != nil {
+		return nil, err
+	}
+	next = append(append(next, committed...), w.Excludes...)
+
+	root := osfs.New("/")
+	ignored, err := gitignore.LoadSystemPatterns(root)
+	if err != nil {
+		return nil, err
+	}
+	global, err := gitignore.LoadGlobalPatterns(root)
+	if err != nil {
+		return nil, err
+	}
+	ignored = append(append(append(ignored, global...), current...), committed...)
+
+	currentMatcher, nextMatcher, ignoredMatcher := gitignore.NewMatcher(current), gitignore.NewMatcher(next), gitignore.NewMatcher(ignored)
+	kept := func(parts []string, isDir bool) bool {
+		return currentMatcher.Match(parts, isDir) && nextMatcher.Match(parts, isDir)
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{}
+	for _, e := range idx.Entries {
+		tracked[e.Name] = true
+	}
+
+	var clobbered []string
+	err = filepath.WalkDir(top, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == top {
+			return nil
+		}
+		rel, err := filepath.Rel(top, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		parts := strings.Split(rel, "/")
+		if d.IsDir() {
+			if d.Name() == ".git" || kept(parts, true) {
+				return filepath.SkipDir
+			}
+			// Nested repos (and submodules) aren't touched by checkouts of this one.
+			if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !tracked[rel] && ignoredMatcher.Match(parts, false) && !kept(parts, false) {
+			clobbered = append(clobbered, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(clobbered)
+
+	return clobbered, nil
+}
+
+// treeIgnorePatterns - Patterns of all .gitignore files of the tree, in the order ReadPatterns reads them from disk.
+func treeIgnorePatterns(tree *object.Tree) ([]gitignore.Pattern, error) {
+	var patterns []gitignore.Pattern
+	err := tree.Files().ForEach(func(f *object.File) error {
+		dir, name := path.Split(f.Name)
+		if name != ".gitignore" || !f.Mode.IsFile() {
+			return nil
+		}
+		var domain []string
+		if dir = strings.Trim(dir, "/"); dir != "" {
+			domain = strings.Split(dir, "/")
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, parseIgnorePatterns(content, domain)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return patterns, nil
+}
+
+// parseIgnorePatterns - Patterns of the gitignore file content, relative to the domain directory.
+func parseIgnorePatterns(content []byte, domain []string) []gitignore.Pattern {
+	var patterns []gitignore.Pattern
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, "#") && strings.TrimSpace(line) != "" {
+			patterns = append(patterns, gitignore.ParsePattern(line, domain))
+		}
+	}
+	return patterns
+}
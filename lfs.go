@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// lfsSkipSmudgeEnv - Env variable making git-lfs leave pointer files as they are, for git commands run in repos with
+// skipLFS.
+const lfsSkipSmudgeEnv = "GIT_LFS_SKIP_SMUDGE=1"
+
+// usesLFS - Whether .gitattributes at the root of the commit's tree routes any paths through the Git LFS filter.
+// go-git runs no filters, such paths are checked out and pushed as pointer files.
+func usesLFS(repo *git.Repository, hash plumbing.Hash) (bool, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return false, err
+	}
+	file, err := commit.File(".gitattributes")
+	if err == object.ErrFileNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return false, err
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		// Pattern followed by its attributes.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if attr == "filter=lfs" {
+				return true, nil
+			}
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// lockedWriter - writer serializing writes, so log lines of concurrently synced repos and flushed groups of them never
+// interleave.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
+
+// logOutput - output of all logs, installed by setupLogging.
+var logOutput = &lockedWriter{w: os.Stderr}
+
+// logFileOptions - rotating log file from the command line, see setupLogging.
+type logFileOptions struct {
+	path       string
+	maxSizeMB  int
+	maxAgeDays int
+	maxBackups int
+	only       bool
+}
+
+// setupLogging - Route logs of the level and above through logOutput. With log file path set, logs are written to the
+// file (rotated once it reaches the max size, rotated files pruned by age and count) as well as stderr, or only to the
+// file.
+func setupLogging(level log.Level, file logFileOptions) {
+	log.SetLevel(level)
+	if file.path != "" {
+		rotated := &lumberjack.Logger{
+			Filename:   file.path,
+			MaxSize:    file.maxSizeMB,
+			MaxAge:     file.maxAgeDays,
+			MaxBackups: file.maxBackups,
+		}
+		if file.only {
+			logOutput.w = rotated
+		} else {
+			logOutput.w = io.MultiWriter(os.Stderr, rotated)
+		}
+	}
+	log.SetOutput(logOutput)
+}
+
+// repoLogger - Return logger for messages about syncing the repo and function writing them out once it's done. In
+// parallel mode messages are tagged with the repo name. With grouped logs they're buffered and written out all at once
+// by the returned function, otherwise they're written right away and the function does nothing.
+func repoLogger(rs *Repo, run *runOptions) (*log.Entry, func()) {
+	std := log.StandardLogger()
+	if !run.groupedLogs {
+		if run.parallel > 1 {
+			return std.WithField("repo", rs.Name), func() {}
+		}
+		return log.NewEntry(std), func() {}
+	}
+
+	buf := &bytes.Buffer{}
+	logger := log.New()
+	logger.SetOutput(buf)
+	logger.SetLevel(std.GetLevel())
+	logger.SetFormatter(std.Formatter)
+
+	return logger.WithField("repo", rs.Name), func() {
+		if _, err := std.Out.Write(buf.Bytes()); err != nil {
+			log.Warnf("failed to write logs of repo '%s': %v", rs.Name, err)
+		}
+	}
+}
@@ -20,64 +20,1016 @@ THE SOFTWARE.
 */
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
+// maxCountedCommits - Cap for counting commits pushed to a branch, so new branches with long history don't walk all of it.
+const maxCountedCommits = 10000
+
+// stringList - flag value collecting all occurrences of a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 // Remote - struct for reading info about remote from input YAML.
 type Remote struct {
 	Name string `yaml:"name"`
 	Url  string `yaml:"url,omitempty"`
+	// Username and token (from TokenFile, else TokenEnv) authenticate HTTPS operations with basic auth.
+	Username  string `yaml:"username,omitempty"`
+	TokenEnv  string `yaml:"tokenEnv,omitempty"`
+	TokenFile string `yaml:"tokenFile,omitempty"`
+	// GithubApp authenticates HTTPS operations with an installation token of the GitHub App.
+	GithubApp *GithubApp `yaml:"githubApp,omitempty"`
+	// HttpHeaders are set on HTTP(S) requests to the remote, values may refer to env variables (`${TOKEN}`).
+	HttpHeaders map[string]string `yaml:"httpHeaders,omitempty"`
+
+	token string
 }
 
 // Repo - struct for reading repository info from input YAML.
 type Repo struct {
-	Name         string
-	Path         string  `yaml:"path"`
+	Name string
+	Path string `yaml:"path,omitempty"`
+	// SourcePath is a separate local clone to fetch from, used as URL of the source remote instead of its url.
+	SourcePath   string  `yaml:"sourcePath,omitempty"`
 	SourceRemote *Remote `yaml:"sourceRemote"`
-	TargetRemote *Remote `yaml:"targetRemote"`
+	// SourceRemotes, instead of SourceRemote, are tried in order, syncing from the first one responding.
+	SourceRemotes []*Remote `yaml:"sourceRemotes,omitempty"`
+	TargetRemote  *Remote   `yaml:"targetRemote"`
+	// DefaultBranch is the (target side) branch HEAD should point at when syncing into an empty target.
+	DefaultBranch string `yaml:"defaultBranch,omitempty"`
+	// SyncHead points target's HEAD at the (mapped) branch source's HEAD points at.
+	SyncHead bool `yaml:"syncHead,omitempty"`
+	// TargetDefaultBranch is the (target side) branch target's HEAD is pointed at after syncing, regardless of source's
+	// HEAD, when the target has it.
+	TargetDefaultBranch string `yaml:"targetDefaultBranch,omitempty"`
+	// SyncNotes enables mirroring of git notes - refs/notes/commits and any of NoteRefs.
+	SyncNotes bool     `yaml:"syncNotes,omitempty"`
+	NoteRefs  []string `yaml:"noteRefs,omitempty"`
+	// ExtraRefs lists other ref namespaces to mirror read-only, e.g. pull request heads.
+	ExtraRefs []*RefMirror `yaml:"extraRefs,omitempty"`
+	// PrimaryBranch is the (source side) default branch of the source, instead of detecting it from source's HEAD.
+	PrimaryBranch string `yaml:"primaryBranch,omitempty"`
+	// DefaultBranchOnly limits syncing to the branch source remote's HEAD points at.
+	DefaultBranchOnly bool `yaml:"defaultBranchOnly,omitempty"`
+	// Namespace, e.g. `refs/mirror`, moves all refs pushed to the target under it, keeping their path without the
+	// `refs/` prefix (`refs/heads/main` lands as `refs/mirror/heads/main`).
+	Namespace string `yaml:"namespace,omitempty"`
+	// SourceRefNamespace, e.g. `refs/publish`, is where branches are collected from on the source instead of
+	// `refs/heads`, they're synced as branches of the same name.
+	SourceRefNamespace string `yaml:"sourceRefNamespace,omitempty"`
+	// CreateTargetRemote toggles adding the target remote to the repo when it's missing, enabled when not set.
+	CreateTargetRemote *bool `yaml:"createTargetRemote,omitempty"`
+	// SyncBranches and SyncTags toggle syncing of branches and tags respectively, both are enabled when not set.
+	SyncBranches *bool `yaml:"syncBranches,omitempty"`
+	SyncTags     *bool `yaml:"syncTags,omitempty"`
+	// IncludeBranches and ExcludeBranches filter source branches by glob patterns (path.Match syntax).
+	IncludeBranches []string `yaml:"includeBranches,omitempty"`
+	ExcludeBranches []string `yaml:"excludeBranches,omitempty"`
+	// MergedInto limits syncing to branches merged into the named source branch (their tips being its ancestors).
+	MergedInto string `yaml:"mergedInto,omitempty"`
+	// BranchAuthorDomain limits syncing to branches whose tip commit author email is in one of the domains.
+	BranchAuthorDomain []string `yaml:"branchAuthorDomain,omitempty"`
+	// CommitGraph writes commit-graph of the repo after fetching the source, speeding up ancestry checks of filters.
+	CommitGraph bool `yaml:"commitGraph,omitempty"`
+	// IncrementalFetch fetches source branches one by one, so an interrupted fetch keeps already fetched branches.
+	IncrementalFetch bool `yaml:"incrementalFetch,omitempty"`
+	// SparseCheckout limits directories materialized in the worktree when checking out branches.
+	SparseCheckout []string `yaml:"sparseCheckout,omitempty"`
+	// SkipLFS acknowledges mirroring only pointer files of Git LFS paths, see usesLFS.
+	SkipLFS bool `yaml:"skipLFS,omitempty"`
+	// SafePaths refuses to check out branches with entries escaping the worktree root, see checkTreePaths.
+	SafePaths bool `yaml:"safePaths,omitempty"`
+	// SkipCommitMarker skips branches whose source tip commit message contains the marker, e.g. `[no-mirror]`.
+	SkipCommitMarker string `yaml:"skipCommitMarker,omitempty"`
+	// RequireSignedTip syncs only branches whose tip commit is signed by a key of SigningKeyring (armored public keys),
+	// others are skipped or fail the repo as UnsignedTip says, see verifySignedTip.
+	RequireSignedTip bool   `yaml:"requireSignedTip,omitempty"`
+	SigningKeyring   string `yaml:"signingKeyring,omitempty"`
+	UnsignedTip      string `yaml:"unsignedTip,omitempty"`
+	// TagsSince skips tags older than given duration.
+	TagsSince duration `yaml:"tagsSince,omitempty"`
+	// TagTriggered skips syncing the repo until its source has a tag not seen by the last successful sync.
+	TagTriggered bool `yaml:"tagTriggered,omitempty"`
+	// ContinueOnTagError pushes remaining tags when one fails, failing the repo once all were tried.
+	ContinueOnTagError bool `yaml:"continueOnTagError,omitempty"`
+	// TagOrder is the order tags are pushed in, tagOrderSemver or tagOrderLexical (default). LatestTags
+	// are pushed last, in the given order, see orderTags.
+	TagOrder   string   `yaml:"tagOrder,omitempty"`
+	LatestTags []string `yaml:"latestTags,omitempty"`
+	// Retries and OpTimeout override --retries and --op-timeout for the repo.
+	Retries   *int     `yaml:"retries,omitempty"`
+	OpTimeout duration `yaml:"opTimeout,omitempty"`
+	// FetchRetries and PushRetries override Retries for fetching (and cloning, pulling) and pushing respectively.
+	FetchRetries *int `yaml:"fetchRetries,omitempty"`
+	PushRetries  *int `yaml:"pushRetries,omitempty"`
+	// PackWindow, ObjectCacheBytes and LargeObjectBytes tune memory go-git uses for the repo, see openRepo.
+	PackWindow       *uint    `yaml:"packWindow,omitempty"`
+	ObjectCacheBytes byteSize `yaml:"objectCacheBytes,omitempty"`
+	LargeObjectBytes byteSize `yaml:"largeObjectBytes,omitempty"`
+	// Provider, when set, mirrors repository description and homepage via provider's API after syncing refs.
+	Provider *Provider `yaml:"provider,omitempty"`
+	// UpdateStrategy is how local branches are brought to source tips before pushing, updateReset (default) or
+	// updatePull.
+	UpdateStrategy string `yaml:"updateStrategy,omitempty"`
+	// Gc prunes and repacks objects of the repo after syncing, like --gc does for all repos.
+	Gc bool `yaml:"gc,omitempty"`
+	// SignedPush asks for pushes signed with a push certificate. go-git can't sign pushes, so it's rejected by validate
+	// rather than silently pushing unsigned.
+	SignedPush bool `yaml:"signedPush,omitempty"`
+	// CaseCollisions is how source branches differing only in case are handled, collisionsError (default) or
+	// collisionsPush.
+	CaseCollisions string `yaml:"caseCollisions,omitempty"`
+	// CheckoutForce and CheckoutKeep are Force and Keep options of checking out branches, forced and not kept when not
+	// set.
+	CheckoutForce *bool `yaml:"checkoutForce,omitempty"`
+	CheckoutKeep  bool  `yaml:"checkoutKeep,omitempty"`
+	// IgnoredFiles is what's done when checking out a branch would remove or overwrite files ignored by git, e.g. local
+	// build artifacts: ignoredFilesOverwrite (default), ignoredFilesWarn or ignoredFilesAbort failing the repo.
+	IgnoredFiles string `yaml:"ignoredFiles,omitempty"`
+	// ProtectDivergent skips pushing branches whose target tip isn't an ancestor of the source tip, unless the divergence
+	// lasts DivergentGrace (when set) or --allow-divergent-overwrite is given.
+	ProtectDivergent bool     `yaml:"protectDivergent,omitempty"`
+	DivergentGrace   duration `yaml:"divergentGrace,omitempty"`
+	// MaxPushObjects and MaxPushBytes abort pushes estimated to transfer more objects or (uncompressed) bytes, unless
+	// --allow-large-push is given, see checkPushSize.
+	MaxPushObjects int      `yaml:"maxPushObjects,omitempty"`
+	MaxPushBytes   byteSize `yaml:"maxPushBytes,omitempty"`
+	// Fsck verifies integrity of the local object store after fetching, failing the repo before anything is pushed
+	// from a damaged one, see fsckRepo.
+	Fsck bool `yaml:"fsck,omitempty"`
+	// VerifyPush lists the target after pushing, failing when synced branches and tags don't point where expected.
+	VerifyPush bool `yaml:"verifyPush,omitempty"`
+	// PostSync is a shell command run after the repo is synced successfully, see runPostSync.
+	PostSync string `yaml:"postSync,omitempty"`
+	// Env holds extra env variables of the PostSync command.
+	Env map[string]string `yaml:"env,omitempty"`
+	// SubtreePrefix pushes branches with history rewritten to nest their content under the directory, see
+	// subtreeRewriter.
+	SubtreePrefix string `yaml:"subtreePrefix,omitempty"`
+	// GerritReview pushes branches to Gerrit review refs `refs/for/<mapped branch>` creating changes, instead of updating
+	// the branches themselves, see branchPushSpec.
+	GerritReview bool `yaml:"gerritReview,omitempty"`
+	// PushRefSpecTemplate replaces the refspec branches are pushed with, e.g. `{force}{source}:refs/heads/mirror/{mapped}`,
+	// see expandPushRefSpec.
+	PushRefSpecTemplate string `yaml:"pushRefSpecTemplate,omitempty"`
+	// TargetHooks are server hook scripts copied into hooks directory of local target repos, see copyTargetHooks.
+	TargetHooks []string `yaml:"targetHooks,omitempty"`
+	// AfterPush is an HTTP request sent after the repo is pushed, see sendAfterPush.
+	AfterPush *AfterPush `yaml:"afterPush,omitempty"`
+
+	keyring openpgp.EntityList
+}
+
+// sourceRemotes - Remotes the repo can be synced from, in order of preference.
+func (r *Repo) sourceRemotes() []*Remote {
+	if len(r.SourceRemotes) > 0 {
+		return r.SourceRemotes
+	}
+	return []*Remote{r.SourceRemote}
+}
+
+// sourceUrl - URL the source remote of the repo fetches from, SourcePath when set, otherwise url of the remote.
+func (r *Repo) sourceUrl() string {
+	if r.SourcePath != "" {
+		return r.SourcePath
+	}
+	return r.SourceRemote.Url
+}
+
+// Update strategies of local branches, see Repo.UpdateStrategy.
+const (
+	updateReset = "reset"
+	updatePull  = "pull"
+)
+
+// updateStrategy - Effective update strategy of the repo's local branches.
+func (r *Repo) updateStrategy() string {
+	if r.UpdateStrategy == "" {
+		return updateReset
+	}
+	return r.UpdateStrategy
+}
+
+// Handling of branches differing only in case, see Repo.CaseCollisions.
+const (
+	collisionsError = "error"
+	collisionsPush  = "push"
+)
+
+// caseCollisions - Effective handling of the repo's branches differing only in case.
+func (r *Repo) caseCollisions() string {
+	if r.CaseCollisions == "" {
+		return collisionsError
+	}
+	return r.CaseCollisions
+}
+
+// checkoutForce - Whether checking out branches of the repo discards local changes, enabled when not set.
+func (r *Repo) checkoutForce() bool {
+	return r.CheckoutForce == nil || *r.CheckoutForce
+}
+
+// resetMode - Mode of resetting checked out branches to source tips, matching checkout options: hard when forced, soft
+// (index and worktree left as they are) when kept, otherwise merging. go-git's hard and merge resets remove untracked
+// files too.
+func (r *Repo) resetMode() git.ResetMode {
+	switch {
+	case r.checkoutForce():
+		return git.HardReset
+	case r.CheckoutKeep:
+		return git.SoftReset
+	}
+	return git.MergeReset
+}
+
+// retryCount - Effective number of retries of remote operations of the repo, its own override or the global one.
+func (r *Repo) retryCount(global int) int {
+	if r.Retries != nil {
+		return *r.Retries
+	}
+
+	return global
+}
+
+// fetchRetries - Effective number of retries of fetches of the repo.
+func (r *Repo) fetchRetries(global int) int {
+	if r.FetchRetries != nil {
+		return *r.FetchRetries
+	}
+
+	return r.retryCount(global)
+}
+
+// pushRetries - Effective number of retries of pushes of the repo.
+func (r *Repo) pushRetries(global int) int {
+	if r.PushRetries != nil {
+		return *r.PushRetries
+	}
+
+	return r.retryCount(global)
+}
+
+// timeout - Effective timeout of remote operations of the repo, its own override or the global one.
+func (r *Repo) timeout(global time.Duration) time.Duration {
+	if r.OpTimeout > 0 {
+		return time.Duration(r.OpTimeout)
+	}
+
+	return global
+}
+
+// matchesAny - Whether name matches any of the glob patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// branchSelected - Whether the branch passes repo's include/exclude filters and, when given, the run-wide list of
+// branches to sync.
+func (r *Repo) branchSelected(branchName string, onlyBranches []string) bool {
+	if len(onlyBranches) > 0 && !matchesAny(branchName, onlyBranches) {
+		return false
+	}
+	if len(r.IncludeBranches) > 0 && !matchesAny(branchName, r.IncludeBranches) {
+		return false
+	}
+
+	return !matchesAny(branchName, r.ExcludeBranches)
+}
+
+// branchesEnabled - Whether branches of the repo should be synced.
+func (r *Repo) branchesEnabled() bool {
+	return r.SyncBranches == nil || *r.SyncBranches
+}
+
+// targetRemoteCreated - Whether missing target remote should be added to the repo.
+func (r *Repo) targetRemoteCreated() bool {
+	return r.CreateTargetRemote == nil || *r.CreateTargetRemote
+}
+
+// tagsEnabled - Whether tags of the repo should be synced.
+func (r *Repo) tagsEnabled() bool {
+	return r.SyncTags == nil || *r.SyncTags
+}
+
+// RefMirror - struct for reading info about extra refs to mirror from input YAML. Source refs are fetched under the
+// same name and pushed as target. Both may contain a single `*` wildcard, e.g. `refs/pull/*/head` pushed as
+// `refs/merge-requests/*/head`.
+type RefMirror struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// fetchRefSpec - Refspec fetching source refs under the same name.
+func (m *RefMirror) fetchRefSpec() config.RefSpec {
+	return config.RefSpec(fmt.Sprintf("+%s:%s", m.Source, m.Source))
+}
+
+// pushRefSpec - Refspec pushing fetched source refs as target refs.
+func (m *RefMirror) pushRefSpec() config.RefSpec {
+	return config.RefSpec(fmt.Sprintf("+%s:%s", m.Source, m.Target))
+}
+
+// extraRefs - Return refs to be mirrored besides branches and tags - notes, when enabled (refs/notes/commits and
+// configured NoteRefs, which may contain wildcards), and configured ExtraRefs.
+func (r *Repo) extraRefs() []*RefMirror {
+	var mirrors []*RefMirror
+	if r.SyncNotes {
+		for _, n := range append([]string{"refs/notes/commits"}, r.NoteRefs...) {
+			mirrors = append(mirrors, &RefMirror{Source: n, Target: n})
+		}
+	}
+
+	mirrors = append(mirrors, r.ExtraRefs...)
+	if r.Namespace == "" {
+		return mirrors
+	}
+
+	namespaced := make([]*RefMirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		if m == nil {
+			namespaced = append(namespaced, m)
+			continue
+		}
+		namespaced = append(namespaced, &RefMirror{Source: m.Source, Target: r.targetRef(plumbing.ReferenceName(m.Target)).String()})
+	}
+
+	return namespaced
+}
+
+// targetRef - Return name the ref is pushed as to the target, moved under Namespace when set.
+func (r *Repo) targetRef(name plumbing.ReferenceName) plumbing.ReferenceName {
+	if r.Namespace == "" {
+		return name
+	}
+
+	return plumbing.ReferenceName(r.Namespace + "/" + strings.TrimPrefix(name.String(), "refs/"))
+}
+
+// gerritReviewPrefix - prefix of refs pushing to which creates Gerrit changes for review of the branch they name.
+const gerritReviewPrefix = "refs/for/"
+
+// branchPushSpec - Refspec pushing the local ref as the mapped branch: PushRefSpecTemplate expanded when set,
+// otherwise forced update of the target branch, or with GerritReview a plain push to its review ref, leaving it to
+// Gerrit to update the branch once changes are submitted.
+func (r *Repo) branchPushSpec(local plumbing.ReferenceName, mapped string) (config.RefSpec, error) {
+	if r.PushRefSpecTemplate != "" {
+		return r.expandPushRefSpec(local, mapped)
+	}
+	if r.GerritReview {
+		return config.RefSpec(fmt.Sprintf("%s:%s%s", local, gerritReviewPrefix, mapped)), nil
+	}
+	return config.RefSpec(fmt.Sprintf("+%s:%s", local, r.targetRef(plumbing.NewBranchReferenceName(mapped)))), nil
+}
+
+// expandPushRefSpec - Fill placeholders of PushRefSpecTemplate: {source} (full name of the local ref pushed),
+// {mapped} (mapped branch name) and {force} (`+`, empty with GerritReview). Fails on unknown placeholders and
+// refspecs that aren't a single non-wildcard one pushing to a ref.
+func (r *Repo) expandPushRefSpec(local plumbing.ReferenceName, mapped string) (config.RefSpec, error) {
+	values := map[string]string{"{source}": local.String(), "{mapped}": mapped, "{force}": "+"}
+	if r.GerritReview {
+		values["{force}"] = ""
+	}
+
+	expanded, err := expandTemplate(r.PushRefSpecTemplate, values, nil)
+	if err != nil {
+		return "", err
+	}
+	spec := config.RefSpec(expanded)
+	if err := spec.Validate(); err != nil {
+		return "", fmt.Errorf("invalid refspec '%s': %v", spec, err)
+	}
+	if spec.IsWildcard() || spec.IsDelete() || !strings.HasPrefix(spec.Dst(local).String(), "refs/") {
+		return "", fmt.Errorf("invalid refspec '%s': must push one ref to a ref under refs/", spec)
+	}
+
+	return spec, nil
+}
+
+// sourceRef - Inverse of targetRef, return name of target ref as on the source. Refs outside Namespace (when set)
+// yield false.
+func (r *Repo) sourceRef(name plumbing.ReferenceName) (plumbing.ReferenceName, bool) {
+	if r.Namespace == "" {
+		return name, true
+	}
+
+	rest := strings.TrimPrefix(name.String(), r.Namespace+"/")
+	if rest == name.String() {
+		return "", false
+	}
+
+	return plumbing.ReferenceName("refs/" + rest), true
+}
+
+// sourceBranch - Name of the branch the source ref is synced as, false for refs that aren't branches (outside
+// SourceRefNamespace when set).
+func (r *Repo) sourceBranch(name plumbing.ReferenceName) (plumbing.ReferenceName, bool) {
+	if r.SourceRefNamespace == "" {
+		return name, name.IsBranch()
+	}
+
+	rest := strings.TrimPrefix(name.String(), r.SourceRefNamespace+"/")
+	if rest == name.String() {
+		return "", false
+	}
+
+	return plumbing.NewBranchReferenceName(rest), true
+}
+
+// sourceFetchRefSpecs - Refspecs fetching source branches from SourceRefNamespace into remote-tracking refs, nil when
+// it's not set and the remote's own refspecs apply.
+func (r *Repo) sourceFetchRefSpecs() []config.RefSpec {
+	if r.SourceRefNamespace == "" {
+		return nil
+	}
+
+	return []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s/*:refs/remotes/%s/*", r.SourceRefNamespace, r.SourceRemote.Name))}
+}
+
+// branchFetchRefSpec - Refspec fetching only the source branch into its remote-tracking ref.
+func (r *Repo) branchFetchRefSpec(branch string) config.RefSpec {
+	source := plumbing.NewBranchReferenceName(branch).String()
+	if r.SourceRefNamespace != "" {
+		source = r.SourceRefNamespace + "/" + branch
+	}
+
+	return config.RefSpec(fmt.Sprintf("+%s:%s", source, plumbing.NewRemoteReferenceName(r.SourceRemote.Name, branch)))
 }
 
 // RepoSync - struct for reading sync info from input YAML.
 type RepoSync struct {
 	Repos         map[string]*Repo  `yaml:"repos"`
 	BranchMapping map[string]string `yaml:"branchMapping"`
+	// BranchMappingFile is a YAML (or JSON) file with branch mapping entries, relative to the config file it's set in.
+	// Inline BranchMapping entries override its ones.
+	BranchMappingFile string `yaml:"branchMappingFile,omitempty"`
+	// UserAgent identifies the tool in HTTP(S) requests, defaults to go-repo-sync/<version>.
+	UserAgent string `yaml:"userAgent,omitempty"`
+	// MaxConcurrentRemoteOps limits fetches, pushes and other remote operations running at once per server (host of
+	// the remote url) across all repos, independently of --parallel. Unlimited when not set.
+	MaxConcurrentRemoteOps int `yaml:"maxConcurrentRemoteOps,omitempty"`
+	// HostLimits overrides MaxConcurrentRemoteOps for servers by their host (with port when not default), e.g. more
+	// operations at once with a robust server and fewer with a fragile one.
+	HostLimits map[string]int `yaml:"hostLimits,omitempty"`
+	// BaseDir is prepended to relative repo paths of the config file it's set in.
+	BaseDir string `yaml:"baseDir,omitempty"`
+	// OnError is a shell command run once per sync cycle when any repos failed, see runOnError.
+	OnError string `yaml:"onError,omitempty"`
+	// AllowedHours limits daemon sync cycles to a daily window, see parseHoursWindow.
+	AllowedHours string `yaml:"allowedHours,omitempty"`
+	// GcEveryNCycles runs gc of daemon's repos only every n-th sync cycle, unless --gc-every is given.
+	GcEveryNCycles int `yaml:"gcEveryNCycles,omitempty"`
+	// Cooldown skips daemon's repos for the duration once they failed CooldownAfterFailures times in a row (3 when not
+	// set), tracked in state of the run.
+	Cooldown              duration `yaml:"cooldown,omitempty"`
+	CooldownAfterFailures int      `yaml:"cooldownAfterFailures,omitempty"`
+	// Events, when set, publishes an event for every ref updated on targets, see publishEvents.
+	Events *Events `yaml:"events,omitempty"`
+	// TargetUrlTemplate is url of target remotes not having one, with placeholders filled from the source url, see
+	// expandTargetUrl.
+	TargetUrlTemplate string `yaml:"targetUrlTemplate,omitempty"`
 }
 
-// readInput - Read info about syncing repositories from input YAML file. Returns RepoSync struct.
-func (rs *RepoSync) readInput(path string) (*RepoSync, error) {
+// readInput - Read info about syncing repositories from input YAML file. Returns RepoSync struct. When strict is set,
+// unknown or misspelled keys are reported as errors instead of being silently ignored.
+func (rs *RepoSync) readInput(path string, strict bool) (*RepoSync, error) {
 	yamlFile, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("failed to read Yaml file '%s': %v ", path, err)
-		return nil, err
+		return nil, fmt.Errorf("%w: failed to read Yaml file '%s': %v", ErrConfigInvalid, path, err)
 	}
 
-	err = yaml.Unmarshal(yamlFile, &rs)
-	if err != nil {
-		log.Fatalf("failed to unmarshal input: %v", err)
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(strict)
+	err = decoder.Decode(&rs)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w: failed to unmarshal input '%s': %v", ErrConfigInvalid, path, err)
+	}
+	if rs == nil {
+		rs = &RepoSync{}
+	}
+
+	if rs.BranchMappingFile != "" {
+		file := rs.BranchMappingFile
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		mapping, err := readBranchMapping(file)
+		if err != nil {
+			return nil, fmt.Errorf("%w: branch mapping of '%s': %v", ErrConfigInvalid, path, err)
+		}
+		for k, v := range rs.BranchMapping {
+			mapping[k] = v
+		}
+		rs.BranchMapping = mapping
 	}
 
 	for k, v := range rs.Repos {
+		if v == nil {
+			continue
+		}
 		v.Name = k
+		paths := []*string{&v.Path, &v.SourcePath}
+		for i := range v.TargetHooks {
+			paths = append(paths, &v.TargetHooks[i])
+		}
+		paths = append(paths, &v.SigningKeyring)
+		for _, p := range paths {
+			if rs.BaseDir != "" && *p != "" && !filepath.IsAbs(*p) {
+				if *p, err = filepath.Abs(filepath.Join(rs.BaseDir, *p)); err != nil {
+					return nil, fmt.Errorf("%w: repo '%s': failed to resolve path: %v", ErrConfigInvalid, k, err)
+				}
+			}
+		}
+		if v.SigningKeyring != "" {
+			if v.keyring, err = readKeyring(v.SigningKeyring); err != nil {
+				return nil, fmt.Errorf("%w: repo '%s': %v", ErrConfigInvalid, k, err)
+			}
+		}
+		if v.SourceRemote == nil && len(v.SourceRemotes) > 0 {
+			v.SourceRemote = v.SourceRemotes[0]
+		}
+		for _, r := range append([]*Remote{v.TargetRemote}, v.sourceRemotes()...) {
+			if r == nil || (r.TokenFile == "" && r.TokenEnv == "") {
+				continue
+			}
+			if r.token, err = readToken(r.TokenFile, r.TokenEnv); err != nil {
+				return nil, fmt.Errorf("%w: repo '%s', remote '%s': %v", ErrConfigInvalid, k, r.Name, err)
+			}
+		}
 	}
 
 	return rs, nil
 }
 
+// defaultCooldownAfterFailures - Consecutive failures putting a repo into cooldown when cooldownAfterFailures isn't set.
+const defaultCooldownAfterFailures = 3
+
+// cooldownAfterFailures - Consecutive failures putting a repo into cooldown.
+func (rs *RepoSync) cooldownAfterFailures() int {
+	if rs.CooldownAfterFailures == 0 {
+		return defaultCooldownAfterFailures
+	}
+	return rs.CooldownAfterFailures
+}
+
+// readBranchMapping - Read branch mapping entries from YAML (or JSON) file.
+func readBranchMapping(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s': %v", path, err)
+	}
+
+	mapping := map[string]string{}
+	if err := yaml.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal '%s': %v", path, err)
+	}
+	return mapping, nil
+}
+
+// readInputs - Read and merge info about syncing repositories from multiple input YAML files. Repos must be unique across
+// the files, branch mapping entries of later files override earlier ones.
+func readInputs(paths []string, strict bool) (*RepoSync, error) {
+	merged := &RepoSync{
+		Repos:         map[string]*Repo{},
+		BranchMapping: map[string]string{},
+	}
+
+	for _, path := range paths {
+		var rs *RepoSync
+		rs, err := rs.readInput(path, strict)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range rs.Repos {
+			if _, ok := merged.Repos[k]; ok {
+				return nil, fmt.Errorf("%w: repo '%s' from '%s' is already defined in another config", ErrConfigInvalid, k, path)
+			}
+			merged.Repos[k] = v
+		}
+		for k, v := range rs.BranchMapping {
+			merged.BranchMapping[k] = v
+		}
+		if rs.UserAgent != "" {
+			merged.UserAgent = rs.UserAgent
+		}
+		if rs.MaxConcurrentRemoteOps != 0 {
+			merged.MaxConcurrentRemoteOps = rs.MaxConcurrentRemoteOps
+		}
+		for host, limit := range rs.HostLimits {
+			if merged.HostLimits == nil {
+				merged.HostLimits = map[string]int{}
+			}
+			merged.HostLimits[strings.ToLower(host)] = limit
+		}
+		if rs.OnError != "" {
+			merged.OnError = rs.OnError
+		}
+		if rs.AllowedHours != "" {
+			merged.AllowedHours = rs.AllowedHours
+		}
+		if rs.GcEveryNCycles != 0 {
+			merged.GcEveryNCycles = rs.GcEveryNCycles
+		}
+		if rs.Cooldown != 0 {
+			merged.Cooldown = rs.Cooldown
+		}
+		if rs.CooldownAfterFailures != 0 {
+			merged.CooldownAfterFailures = rs.CooldownAfterFailures
+		}
+		if rs.Events != nil {
+			merged.Events = rs.Events
+		}
+		if rs.TargetUrlTemplate != "" {
+			merged.TargetUrlTemplate = rs.TargetUrlTemplate
+		}
+	}
+
+	if merged.TargetUrlTemplate != "" {
+		for k, v := range merged.Repos {
+			if v == nil || v.TargetRemote == nil || v.TargetRemote.Url != "" || v.SourceRemote == nil || v.sourceUrl() == "" {
+				continue
+			}
+			url, err := expandTargetUrl(merged.TargetUrlTemplate, k, v.sourceUrl())
+			if err != nil {
+				return nil, fmt.Errorf("%w: repo '%s': targetUrlTemplate: %v", ErrConfigInvalid, k, err)
+			}
+			v.TargetRemote.Url = url
+		}
+	}
+
+	return merged, nil
+}
+
+// templatePlaceholder - placeholder of config templates (targetUrlTemplate, pushRefSpecTemplate), like `{org}`.
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// expandTemplate - Replace placeholders of the template by their values (keyed with braces, e.g. `{org}`). Fails on
+// unknown placeholders and on those check, when set, reports a problem for.
+func expandTemplate(template string, values map[string]string, check func(p, v string) string) (string, error) {
+	var problems []string
+	expanded := templatePlaceholder.ReplaceAllStringFunc(template, func(p string) string {
+		v, ok := values[p]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown placeholder %s", p))
+		} else if check != nil {
+			if problem := check(p, v); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+		return v
+	})
+	if len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, ", "))
+	}
+
+	return expanded, nil
+}
+
+// expandTargetUrl - Fill placeholders of the template from the source url: {host} (with port, when not default),
+// {org} (path up to the last element, e.g. group/subgroup), {name} (last path element without .git) and {repo} (name
+// of the repo in config). Fails on unknown placeholders and on placeholders the source url has no value for.
+func expandTargetUrl(template, repo, sourceUrl string) (string, error) {
+	ep, err := transport.NewEndpoint(sourceUrl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse source url '%s': %v", sourceUrl, err)
+	}
+	path := strings.TrimSuffix(strings.Trim(ep.Path, "/"), ".git")
+	values := map[string]string{"{repo}": repo, "{host}": remoteHost(sourceUrl), "{name}": path, "{org}": ""}
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		values["{org}"], values["{name}"] = path[:i], path[i+1:]
+	}
+
+	return expandTemplate(template, values, func(p, v string) string {
+		if v == "" {
+			return fmt.Sprintf("source url '%s' has no %s", sourceUrl, p)
+		}
+		return ""
+	})
+}
+
+// validate - Check read RepoSync info for missing mandatory values. Returns all found problems in a single error.
+func (rs *RepoSync) validate() error {
+	var problems []string
+
+	if len(rs.Repos) == 0 {
+		problems = append(problems, "no repos configured")
+	}
+	if rs.MaxConcurrentRemoteOps < 0 {
+		problems = append(problems, "negative maxConcurrentRemoteOps")
+	}
+	for host, limit := range rs.HostLimits {
+		if limit <= 0 {
+			problems = append(problems, fmt.Sprintf("hostLimits: limit of '%s' must be positive", host))
+		}
+	}
+	if rs.GcEveryNCycles < 0 {
+		problems = append(problems, "negative gcEveryNCycles")
+	}
+	if rs.Cooldown < 0 || rs.CooldownAfterFailures < 0 {
+		problems = append(problems, "negative cooldown or cooldownAfterFailures")
+	}
+	if rs.Events != nil {
+		problems = append(problems, rs.Events.validate()...)
+	}
+	for from, to := range rs.BranchMapping {
+		// Would build a broken refspec like +refs/heads/main:refs/heads/.
+		if strings.TrimSpace(to) == "" {
+			problems = append(problems, fmt.Sprintf("branchMapping '%s': empty target branch", from))
+		}
+	}
+	if rs.AllowedHours != "" {
+		if _, err := parseHoursWindow(rs.AllowedHours); err != nil {
+			problems = append(problems, fmt.Sprintf("allowedHours: %v", err))
+		}
+	}
+
+	for name, r := range rs.Repos {
+		if r == nil {
+			problems = append(problems, fmt.Sprintf("repo '%s': empty definition", name))
+			continue
+		}
+		if r.Path == "" {
+			// Repo gets cloned into a temporary directory, both remotes need an url.
+			if !r.targetRemoteCreated() {
+				problems = append(problems, fmt.Sprintf("repo '%s': createTargetRemote can't be disabled without path", name))
+			}
+			if r.SourceRemote != nil && r.sourceUrl() == "" {
+				problems = append(problems, fmt.Sprintf("repo '%s': sourceRemote url or sourcePath needed without path", name))
+			}
+			if r.TargetRemote != nil && r.TargetRemote.Url == "" {
+				problems = append(problems, fmt.Sprintf("repo '%s': targetRemote url needed without path", name))
+			}
+		}
+		if r.SourceRemote == nil || r.SourceRemote.Name == "" {
+			problems = append(problems, fmt.Sprintf("repo '%s': missing sourceRemote name", name))
+		}
+		if len(r.SourceRemotes) > 0 {
+			if r.SourceRemote != r.SourceRemotes[0] || r.SourcePath != "" {
+				problems = append(problems, fmt.Sprintf("repo '%s': sourceRemotes can't be combined with sourceRemote and sourcePath", name))
+			}
+			for i, remote := range r.SourceRemotes {
+				if remote == nil || remote.Name == "" {
+					problems = append(problems, fmt.Sprintf("repo '%s': missing name of sourceRemotes entry %d", name, i+1))
+				} else if r.Path == "" && remote.Url == "" {
+					problems = append(problems, fmt.Sprintf("repo '%s': sourceRemotes url needed without path", name))
+				}
+			}
+		}
+		if r.TargetRemote == nil || r.TargetRemote.Name == "" {
+			problems = append(problems, fmt.Sprintf("repo '%s': missing targetRemote name", name))
+		}
+		for _, p := range append(append([]string{}, r.IncludeBranches...), r.ExcludeBranches...) {
+			if _, err := path.Match(p, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("repo '%s': invalid branch pattern '%s'", name, p))
+			}
+		}
+		for _, m := range r.ExtraRefs {
+			if m == nil || m.pushRefSpec().Validate() != nil {
+				problems = append(problems, fmt.Sprintf("repo '%s': invalid extraRefs entry", name))
+			}
+		}
+		if r.Retries != nil && *r.Retries < 0 {
+			problems = append(problems, fmt.Sprintf("repo '%s': negative retries", name))
+		}
+		if (r.FetchRetries != nil && *r.FetchRetries < 0) || (r.PushRetries != nil && *r.PushRetries < 0) {
+			problems = append(problems, fmt.Sprintf("repo '%s': negative fetchRetries or pushRetries", name))
+		}
+		if r.OpTimeout < 0 {
+			problems = append(problems, fmt.Sprintf("repo '%s': negative opTimeout", name))
+		}
+		if r.AfterPush != nil && r.AfterPush.Url == "" {
+			problems = append(problems, fmt.Sprintf("repo '%s': afterPush is missing url", name))
+		}
+		if r.Provider != nil && r.Provider.Type != "github" && r.Provider.Type != "gitlab" {
+			problems = append(problems, fmt.Sprintf("repo '%s': unknown provider type '%s'", name, r.Provider.Type))
+		}
+		if r.Provider != nil && r.Provider.Releases && (r.Provider.Type != "github" || r.Namespace != "" || !r.tagsEnabled()) {
+			problems = append(problems, fmt.Sprintf("repo '%s': provider releases need github provider and tags synced without namespace", name))
+		}
+		for _, remote := range append([]*Remote{r.TargetRemote}, r.sourceRemotes()...) {
+			if remote == nil || remote.GithubApp == nil {
+				continue
+			}
+			if remote.TokenFile != "" || remote.TokenEnv != "" {
+				problems = append(problems, fmt.Sprintf("repo '%s': remote '%s' can't have both githubApp and token", name, remote.Name))
+			}
+			app := remote.GithubApp
+			if app.AppId == 0 || app.InstallationId == 0 || app.PrivateKeyFile == "" {
+				problems = append(problems, fmt.Sprintf("repo '%s': githubApp of remote '%s' needs appId, installationId and privateKeyFile", name, remote.Name))
+			}
+		}
+		if r.Namespace != "" {
+			if !strings.HasPrefix(r.Namespace, "refs/") || strings.HasSuffix(r.Namespace, "/") {
+				problems = append(problems, fmt.Sprintf("repo '%s': namespace must start with 'refs/' and not end with '/'", name))
+			}
+			if r.DefaultBranch != "" || r.SyncHead || r.TargetDefaultBranch != "" {
+				problems = append(problems, fmt.Sprintf("repo '%s': target HEAD can't be set with namespace", name))
+			}
+		}
+		if strings.HasPrefix(r.PrimaryBranch, "refs/") || strings.TrimSpace(r.PrimaryBranch) != r.PrimaryBranch {
+			problems = append(problems, fmt.Sprintf("repo '%s': primaryBranch '%s' must be a plain branch name", name, r.PrimaryBranch))
+		}
+		if r.SyncHead && r.TargetDefaultBranch != "" {
+			problems = append(problems, fmt.Sprintf("repo '%s': syncHead and targetDefaultBranch are exclusive", name))
+		}
+		if r.SourceRefNamespace != "" {
+			if !strings.HasPrefix(r.SourceRefNamespace, "refs/") || strings.HasSuffix(r.SourceRefNamespace, "/") {
+				problems = append(problems, fmt.Sprintf("repo '%s': sourceRefNamespace must start with 'refs/' and not end with '/'", name))
+			}
+			if r.updateStrategy() == updatePull {
+				problems = append(problems, fmt.Sprintf("repo '%s': updateStrategy pull only works with sourceRefNamespace unset", name))
+			}
+		}
+		if s := r.updateStrategy(); s != updateReset && s != updatePull {
+			problems = append(problems, fmt.Sprintf("repo '%s': unknown updateStrategy '%s'", name, s))
+		}
+		if r.MaxPushObjects < 0 || r.MaxPushBytes < 0 {
+			problems = append(problems, fmt.Sprintf("repo '%s': maxPushObjects and maxPushBytes can't be negative", name))
+		}
+		if r.SubtreePrefix != "" {
+			if !validSubtreePrefix(r.SubtreePrefix) {
+				problems = append(problems, fmt.Sprintf("repo '%s': invalid subtreePrefix '%s'", name, r.SubtreePrefix))
+			}
+			// Tags and other refs would push the original, not nested, history.
+			if r.tagsEnabled() || len(r.extraRefs()) > 0 {
+				problems = append(problems, fmt.Sprintf("repo '%s': subtreePrefix syncs branches only, disable syncTags and extra refs", name))
+			}
+		}
+		if r.RequireSignedTip && r.SigningKeyring == "" {
+			problems = append(problems, fmt.Sprintf("repo '%s': requireSignedTip needs signingKeyring", name))
+		}
+		if u := r.unsignedTip(); u != unsignedTipSkip && u != unsignedTipFail {
+			problems = append(problems, fmt.Sprintf("repo '%s': unknown unsignedTip '%s'", name, u))
+		}
+		if r.ObjectCacheBytes < 0 || r.LargeObjectBytes < 0 {
+			problems = append(problems, fmt.Sprintf("repo '%s': objectCacheBytes and largeObjectBytes must not be negative", name))
+		}
+		if r.DivergentGrace < 0 || (r.DivergentGrace > 0 && !r.ProtectDivergent) {
+			problems = append(problems, fmt.Sprintf("repo '%s': divergentGrace must be positive and needs protectDivergent", name))
+		}
+		if r.TagTriggered && !r.tagsEnabled() {
+			problems = append(problems, fmt.Sprintf("repo '%s': tagTriggered needs tags synced", name))
+		}
+		if r.TagOrder != "" && r.TagOrder != tagOrderSemver && r.TagOrder != tagOrderLexical {
+			problems = append(problems, fmt.Sprintf("repo '%s': unknown tagOrder '%s'", name, r.TagOrder))
+		}
+		if c := r.caseCollisions(); c != collisionsError && c != collisionsPush {
+			problems = append(problems, fmt.Sprintf("repo '%s': unknown caseCollisions '%s'", name, c))
+		}
+		if f := r.ignoredFiles(); f != ignoredFilesOverwrite && f != ignoredFilesWarn && f != ignoredFilesAbort {
+			problems = append(problems, fmt.Sprintf("repo '%s': unknown ignoredFiles '%s'", name, f))
+		}
+		if r.CheckoutKeep && r.checkoutForce() {
+			problems = append(problems, fmt.Sprintf("repo '%s': checkoutKeep only applies with checkoutForce: false", name))
+		}
+		if r.PushRefSpecTemplate != "" {
+			if _, err := r.expandPushRefSpec(plumbing.NewBranchReferenceName("main"), "main"); err != nil {
+				problems = append(problems, fmt.Sprintf("repo '%s': pushRefSpecTemplate: %v", name, err))
+			}
+			if r.Namespace != "" || r.VerifyPush {
+				problems = append(problems, fmt.Sprintf("repo '%s': pushRefSpecTemplate can't be combined with namespace or verifyPush", name))
+			}
+		}
+		if r.GerritReview && (r.Namespace != "" || r.VerifyPush) {
+			problems = append(problems, fmt.Sprintf("repo '%s': gerritReview can't be combined with namespace or verifyPush", name))
+		}
+		if r.SignedPush {
+			problems = append(problems, fmt.Sprintf("repo '%s': signedPush isn't supported, go-git can't sign push certificates", name))
+		}
+		if !r.branchesEnabled() && !r.tagsEnabled() {
+			problems = append(problems, fmt.Sprintf("repo '%s': neither branches nor tags are synced", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w: %s", ErrConfigInvalid, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// checkPaths - Check that paths of all repos (those having one) exist and are git repositories, reporting all missing
+// ones at once. Repos synced from a temporary clone have nothing to check.
+func (rs *RepoSync) checkPaths() error {
+	var problems []string
+	for name, r := range rs.Repos {
+		if r.Path == "" {
+			continue
+		}
+		if _, err := os.Stat(r.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("repo '%s': path %s doesn't exist", name, r.Path))
+			continue
+		}
+		if _, err := git.PlainOpen(r.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("repo '%s': path %s isn't a git repository: %v", name, r.Path, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w: %s", ErrConfigInvalid, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// selectRepos - Limit repos to the named ones. Names missing in the config are only warned about, lists of changed
+// repos may cover other configs too.
+func (rs *RepoSync) selectRepos(names []string) {
+	selected := map[string]*Repo{}
+	for _, name := range names {
+		if r, ok := rs.Repos[name]; ok {
+			selected[name] = r
+		} else {
+			log.Warnf("Repo '%s' selected to sync isn't in the config, ignoring it", name)
+		}
+	}
+	rs.Repos = selected
+}
+
+// readRepoNames - Read newline separated repo names from the file, skipping empty lines and `#` comments.
+func readRepoNames(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repo names: %v", err)
+	}
+
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			names = append(names, line)
+		}
+	}
+
+	return names, nil
+}
+
 // mapBranch - Return mapped branches from read RepoSync info, or the same name if there's no mapping.
+// Exact mappings take precedence over trailing-wildcard rules (e.g. `feature/*: incoming/feature/*`), which substitute
+// the matched suffix; when more wildcard rules match, the longest pattern wins.
 func (rs *RepoSync) mapBranch(branchName string) string {
 	if v, ok := rs.BranchMapping[branchName]; ok {
 		return v
-	} else {
+	}
+
+	matched := ""
+	for pattern := range rs.BranchMapping {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(branchName, strings.TrimSuffix(pattern, "*")) &&
+			len(pattern) > len(matched) {
+			matched = pattern
+		}
+	}
+	if matched == "" {
 		return branchName
 	}
+
+	suffix := strings.TrimPrefix(branchName, strings.TrimSuffix(matched, "*"))
+
+	return strings.TrimSuffix(rs.BranchMapping[matched], "*") + suffix
 }
 
 // repoGetLocalBranchForRemote - Checks if repo already has checked out remote branch, if yes, return reference to respective local branch,
@@ -115,197 +1067,577 @@ func repoGetLocalBranchForRemote(repo *git.Repository, remoteBranch *plumbing.Re
 	return foundLocalBranch, err
 }
 
-func main() {
-	var repoSync *RepoSync
-	repoSync, err := repoSync.readInput(os.Args[1])
+// tagDate - Return date of the tag - tagger date of annotated tags, commit date of the tagged commit for lightweight ones.
+func tagDate(repo *git.Repository, tag *plumbing.Reference) (time.Time, error) {
+	tagObject, err := repo.TagObject(tag.Hash())
+	if err == nil {
+		return tagObject.Tagger.When, nil
+	}
+	if err != plumbing.ErrObjectNotFound {
+		return time.Time{}, err
+	}
+
+	commit, err := repo.CommitObject(tag.Hash())
 	if err != nil {
-		panic(err.Error())
+		return time.Time{}, err
 	}
 
-	for _, rs := range repoSync.Repos {
-		log.Infof("Opening %s...", rs.Path)
-		repo, err := git.PlainOpen(rs.Path)
-		if err != nil {
-			log.Errorf("failed to open repo from %s: %v", rs.Path, err)
-			os.Exit(1)
+	return commit.Committer.When, nil
+}
+
+// caseCollisions - Groups of branches whose names differ only in case, in order of the branches.
+func caseCollisions(branches []*plumbing.Reference) [][]plumbing.ReferenceName {
+	byName := map[string][]plumbing.ReferenceName{}
+	var order []string
+	for _, b := range branches {
+		key := strings.ToLower(b.Name().String())
+		if len(byName[key]) == 0 {
+			order = append(order, key)
+		}
+		byName[key] = append(byName[key], b.Name())
+	}
+
+	var groups [][]plumbing.ReferenceName
+	for _, key := range order {
+		if len(byName[key]) > 1 {
+			groups = append(groups, byName[key])
 		}
+	}
+
+	return groups
+}
 
-		remotes, err := repo.Remotes()
+// filterMerged - Split branches into those whose tips are ancestors of base commit, i.e. merged into it, and the rest.
+// Uses repo's commit-graph when it has one, see commitNodes.
+func filterMerged(repo *git.Repository, branches []*plumbing.Reference, base plumbing.Hash) (
+	merged, unmerged []*plumbing.Reference, err error) {
+	nodes, closer := commitNodes(repo)
+	defer closer.Close()
+
+	baseNode, err := nodes.Get(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, b := range branches {
+		tip, err := nodes.Get(b.Hash())
 		if err != nil {
-			log.Errorf("failed to get remotes for %s: %v", rs.Path, err)
-			os.Exit(1)
+			return nil, nil, err
+		}
+		ok, err := isAncestorNode(tip, baseNode)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			merged = append(merged, b)
+		} else {
+			unmerged = append(unmerged, b)
 		}
+	}
+
+	return merged, unmerged, nil
+}
+
+// authoredInDomain - Whether the author email of the commit is in one of the domains, case-insensitively.
+func authoredInDomain(commit *object.Commit, domains []string) bool {
+	email := strings.ToLower(commit.Author.Email)
+	for _, d := range domains {
+		if strings.HasSuffix(email, "@"+strings.ToLower(d)) {
+			return true
+		}
+	}
 
-		// Add target remote if doesn't exist.
-		_, err = repo.Remote(rs.TargetRemote.Name)
+	return false
+}
+
+// countCommits - Count commits reachable from tip, but not from base (zero base counts whole history of tip), stopping
+// at limit.
+func countCommits(repo *git.Repository, tip plumbing.Hash, base plumbing.Hash, limit int) (int, error) {
+	tipCommit, err := repo.CommitObject(tip)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	if !base.IsZero() {
+		baseCommit, err := repo.CommitObject(base)
 		if err != nil {
-			log.Infof("Target remote %s missing for '%s' ... adding %s", rs.TargetRemote.Name, rs.Path, rs.TargetRemote.Url)
-			repo.CreateRemote(&config.RemoteConfig{
-				Name: rs.TargetRemote.Name,
-				URLs: []string{rs.TargetRemote.Url},
-			})
-		}
-
-		var branchesToSync []*plumbing.Reference
-
-		// Fetch everything.
-		for _, remote := range remotes {
-			log.Infof("Found remote '%s' in '%s' repo... fetching", remote.Config().Name, rs.Path)
-			err = remote.Fetch(&git.FetchOptions{
-				RemoteName: remote.String(),
-				Tags:       git.AllTags,
-			})
-			if err != nil && err != git.NoErrAlreadyUpToDate {
-				log.Errorf("failed to fetch %s in '%s' repo: %v", remote.Config().Name, rs.Path, err)
-				os.Exit(1)
-			}
-
-			if remote.Config().Name == rs.SourceRemote.Name {
-				remoteRefs, err := remote.List(&git.ListOptions{})
-				if err != nil {
-					log.Errorf("failed to get remote objects for remote '%s' in repo '%s': %v", remote.Config().Name, rs.Path, err)
-					os.Exit(1)
-				}
+			return 0, err
+		}
+		err = object.NewCommitPreorderIter(baseCommit, nil, nil).ForEach(func(c *object.Commit) error {
+			seen[c.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
 
-				for _, r := range remoteRefs {
-					if r.Name().IsBranch() {
-						log.Infof("Found remote branch '%s' for remote '%s' in repo '%s'.", r.Name(), remote.Config().Name, rs.Path)
-						branchesToSync = append(branchesToSync, r)
-					}
-				}
+	count := 0
+	err = object.NewCommitPreorderIter(tipCommit, seen, nil).ForEach(func(c *object.Commit) error {
+		count++
+		if count >= limit {
+			return storer.ErrStop
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// setRemoteHead - Points HEAD of the remote at given branch. Git protocol can't update symbolic refs, so this is only
+// possible for local (path or file://) remotes, or via provider API when one is given; returns false otherwise.
+func setRemoteHead(ctx context.Context, remote *git.Remote, branchName string, provider *Provider) (bool, error) {
+	remoteUrl := remote.Config().URLs[0]
+	endpoint, err := transport.NewEndpoint(remoteUrl)
+	if err != nil {
+		return false, err
+	}
+	if endpoint.Protocol != "file" {
+		if provider == nil {
+			return false, nil
+		}
+		return true, setProviderDefaultBranch(ctx, provider, remoteUrl, branchName)
+	}
+
+	remoteRepo, err := git.PlainOpen(endpoint.Path)
+	if err != nil {
+		return false, err
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branchName))
+
+	return true, remoteRepo.Storer.SetReference(head)
+}
+
+// defaultBranchFallbacks - Branches assumed to be the default one, in order of preference, when remote's HEAD doesn't
+// tell.
+var defaultBranchFallbacks = []string{"main", "master"}
+
+// resolveDefaultBranch - Return name of the default branch of the remote from its advertised refs: the branch HEAD
+// symref points at; with HEAD advertised only as a hash (no symref capability) the branch at the same commit,
+// preferring fallback names, then the first one by name; without HEAD the first of fallback names the remote has.
+// Empty name when none applies. All detection of source's default branch goes through it, see sourceDefaultBranch.
+func resolveDefaultBranch(remoteRefs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	branches := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, r := range remoteRefs {
+		switch {
+		case r.Name() == plumbing.HEAD:
+			head = r
+		case r.Name().IsBranch():
+			branches[r.Name()] = r.Hash()
+		}
+	}
+	if head != nil && head.Type() == plumbing.SymbolicReference && head.Target().IsBranch() {
+		return head.Target()
+	}
+
+	var candidates []plumbing.ReferenceName
+	for name, hash := range branches {
+		if head == nil || hash == head.Hash() {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+	for _, fallback := range defaultBranchFallbacks {
+		for _, name := range candidates {
+			if name == plumbing.NewBranchReferenceName(fallback) {
+				return name
 			}
 		}
+	}
+	if head != nil && len(candidates) > 0 {
+		return candidates[0]
+	}
+
+	return ""
+}
+
+// sourceDefaultBranch - Return name of the default branch of the source from its advertised refs, PrimaryBranch when
+// set regardless of them.
+func (r *Repo) sourceDefaultBranch(remoteRefs []*plumbing.Reference) plumbing.ReferenceName {
+	if r.PrimaryBranch != "" {
+		return plumbing.NewBranchReferenceName(r.PrimaryBranch)
+	}
+
+	return resolveDefaultBranch(remoteRefs)
+}
 
-		log.Infof("Branches to sync: %v", branchesToSync)
-		for _, remoteBranch := range branchesToSync {
-			w, err := repo.Worktree()
+func main() {
+	configCheck := flag.Bool("config-check", false, "strictly validate the config file and exit")
+	reportPath := flag.String("report", "", "write JSON report of the run to given path")
+	outputFormat := flag.String("output-format", outputText, "format of results printed besides logs, text (only logs) or github-actions (workflow annotations)")
+	var configPaths stringList
+	flag.Var(&configPaths, "config", "config file to read, can be repeated to merge multiple files")
+	retries := flag.Int("retries", 0, "number of retries of failed remote operations, with exponential backoff")
+	opTimeout := durationFlag("op-timeout", 0, "timeout of a single remote operation, a `duration` like 10m (no timeout by default)")
+	reposFlag := flag.String("repos", "", "comma separated names of repos to limit the run to")
+	reposFromFile := flag.String("repos-from-file", "", "file with newline separated names of repos to limit the run to")
+	onlyBranchesFlag := flag.String("only-branches", "", "comma separated branches (glob patterns) to limit the run to")
+	interval := durationFlag("interval", 0, "keep running, syncing all repos every given `duration`, e.g. 15m")
+	gc := flag.Bool("gc", false, "prune and repack objects of repos after syncing them")
+	pruneTags := flag.Bool("prune-tags", false, "delete tags on targets that no longer exist on sources")
+	parallel := flag.Int("parallel", 1, "number of repos synced at the same time")
+	groupedLogs := flag.Bool("grouped-logs", false, "buffer logs of each repo and write them out together once it's synced")
+	gcEvery := flag.Int("gc-every", 1, "with --interval, run gc only every n-th sync cycle")
+	checkPush := flag.Bool("check-push", false, "check that target remotes accept pushes with configured credentials and exit")
+	diffOnly := flag.Bool("diff-only", false, "only report branches and tags differing between sources and targets, exiting with 7 on drift")
+	dryRun := flag.Bool("dry-run", false, "only plan branches and tags to push by comparing source and target refs, with --report written as JSON plan")
+	allowDivergentOverwrite := flag.Bool("allow-divergent-overwrite", false, "force push branches of protectDivergent repos even when targets diverged from sources")
+	allowLargePush := flag.Bool("allow-large-push", false, "push regardless of maxPushObjects and maxPushBytes of repos")
+	quiet := flag.Bool("quiet", false, "log refs already up to date at debug level, keeping logs to actual changes")
+	logLevel := flag.String("log-level", "info", "level of logs written: debug, info, warn or error")
+	logStatus := flag.Bool("log-status", false, "log worktree status after syncing each branch")
+	skipUnchanged := flag.Bool("skip-unchanged", false, "skip checking out and pushing branches the target already has at source tips")
+	skipUnchangedHead := flag.Bool("skip-unchanged-head", false, "skip repos whose source HEAD didn't change since their last successful sync (remembered in --state-file)")
+	stateFile := flag.String("state-file", "", "file to persist state of repos (known tags of tagTriggered ones, source HEADs, ...) in between runs")
+	checkPaths := flag.Bool("check-paths", false, "verify that all repo paths exist and are git repositories before syncing")
+	fetchAllRemotes := flag.Bool("fetch-all-remotes", false, "fetch all remotes of repos, not only source and target")
+	traceFlag := flag.Bool("trace", false, "export OpenTelemetry traces of the run via OTLP (configured by OTEL_EXPORTER_OTLP_* env variables)")
+	traceRepo := flag.String("trace-repo", "", "write protocol trace of remote operations of the named repo (git sessions and HTTP requests) to --trace-repo-file")
+	traceRepoFile := flag.String("trace-repo-file", "repo-trace.log", "with --trace-repo, file the protocol trace is appended to")
+	var logFile logFileOptions
+	flag.StringVar(&logFile.path, "log-file", "", "also write logs to given file, rotated by size")
+	flag.IntVar(&logFile.maxSizeMB, "log-max-size", 100, "with --log-file, size in megabytes the file is rotated at")
+	flag.IntVar(&logFile.maxAgeDays, "log-max-age", 0, "with --log-file, days to keep rotated files for (0 keeps them regardless of age)")
+	flag.IntVar(&logFile.maxBackups, "log-max-backups", 0, "with --log-file, number of rotated files to keep (0 keeps all)")
+	flag.BoolVar(&logFile.only, "log-file-only", false, "with --log-file, don't write logs to stderr")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [plan|list-branches] [--config <config.yaml>]... [<config.yaml>...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	showVersion := flag.Bool("version", false, "print version and exit")
+	flag.Parse()
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "invalid --log-level: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	setupLogging(level, logFile)
+	if *showVersion || (flag.NArg() == 1 && flag.Arg(0) == "version") {
+		fmt.Println(versionString())
+		return
+	}
+	args := flag.Args()
+	var command string
+	if len(args) > 0 && (args[0] == "plan" || args[0] == "list-branches") {
+		command, args = args[0], args[1:]
+	}
+	configPaths = append(configPaths, args...)
+	if len(configPaths) == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if *gcEvery < 1 {
+		log.Errorf("--gc-every must be at least 1")
+		os.Exit(exitUsage)
+	}
+	if *parallel < 1 {
+		log.Errorf("--parallel must be at least 1")
+		os.Exit(exitUsage)
+	}
+	if *outputFormat != outputText && *outputFormat != outputGithubActions {
+		log.Errorf("unknown --output-format '%s', expected %s or %s", *outputFormat, outputText, outputGithubActions)
+		os.Exit(exitUsage)
+	}
+
+	repoSync, err := readInputs(configPaths, *configCheck)
+	if err == nil {
+		err = repoSync.validate()
+	}
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(exitCode(err))
+	}
+	if *configCheck {
+		log.Infof("Config %s is valid", strings.Join(configPaths, ", "))
+		return
+	}
+
+	if *reposFlag != "" || *reposFromFile != "" {
+		var names []string
+		if *reposFlag != "" {
+			names = strings.Split(*reposFlag, ",")
+		}
+		if *reposFromFile != "" {
+			fileNames, err := readRepoNames(*reposFromFile)
 			if err != nil {
-				log.Errorf("failed to get working tree for repository %s: %v", rs.Path, err)
-				os.Exit(1)
+				log.Errorf("%v", err)
+				os.Exit(exitUsage)
 			}
+			names = append(names, fileNames...)
+		}
+		repoSync.selectRepos(names)
+		log.Infof("Syncing %d selected repos", len(repoSync.Repos))
+	}
+	if *checkPaths {
+		if err := repoSync.checkPaths(); err != nil {
+			log.Errorf("%v", err)
+			os.Exit(exitCode(err))
+		}
+	}
 
-			localBranch, err := repoGetLocalBranchForRemote(repo, remoteBranch)
-			if err != nil {
-				log.Errorf("failed to determine whether repo %v already had local copy of branch %s in %s", repo, remoteBranch, rs.Path)
-				os.Exit(1)
-			}
-
-			if localBranch == nil {
-				log.Infof("Checking out branch %s in %s", remoteBranch.Name().Short(), rs.Path)
-				err = w.Checkout(&git.CheckoutOptions{
-					Hash:   remoteBranch.Hash(),
-					Branch: remoteBranch.Name(),
-					Create: true,
-					Force:  true,
-					Keep:   false,
-				})
-				if err != nil {
-					log.Errorf("failed to checkout %s in %s: %v", remoteBranch.Name().Short(), rs.Path, err)
-					os.Exit(1)
-				}
-				localBranch, err = repo.Head()
-				if err != nil {
-					log.Errorf("failed to get branch HEAD after checkout: %v", err)
-					os.Exit(1)
-				}
-				if localBranch.Hash() != remoteBranch.Hash() || localBranch.Name() != remoteBranch.Name() {
-					log.Errorf("failed to check out branch correctly: %s vs %s; %s vs %s",
-						localBranch.Hash(), remoteBranch.Hash(), localBranch.Name(), remoteBranch.Name())
-					os.Exit(1)
+	if err := installTransport(repoSync.UserAgent); err != nil {
+		log.Errorf("failed to set up git transport: %v", err)
+		os.Exit(exitFailure)
+	}
+
+	run := &runOptions{
+		retries:         *retries,
+		opTimeout:       *opTimeout,
+		fetchAllRemotes: *fetchAllRemotes,
+		gc:              *gc,
+		pruneTags:       *pruneTags,
+		parallel:        *parallel,
+		groupedLogs:     *groupedLogs,
+		skipUnchanged:   *skipUnchanged,
+		logStatus:       *logStatus,
+		quiet:           *quiet,
+		allowLargePush:  *allowLargePush,
+		gcEvery:         *gcEvery,
+
+		allowDivergentOverwrite: *allowDivergentOverwrite,
+		skipUnchangedHead:       *skipUnchangedHead,
+	}
+	gcEveryFlagSet := false
+	flag.Visit(func(f *flag.Flag) {
+		gcEveryFlagSet = gcEveryFlagSet || f.Name == "gc-every"
+	})
+	if repoSync.GcEveryNCycles > 0 && !gcEveryFlagSet {
+		run.gcEvery = repoSync.GcEveryNCycles
+	}
+	if *interval == 0 {
+		// The only cycle of a one-off run gcs.
+		run.gcEvery = 1
+	}
+	run.state, err = loadState(*stateFile)
+	if err != nil {
+		log.Errorf("%v", err)
+		os.Exit(exitFailure)
+	}
+	if repoSync.MaxConcurrentRemoteOps > 0 || len(repoSync.HostLimits) > 0 {
+		run.remoteHosts = newHostSlots(repoSync.MaxConcurrentRemoteOps, repoSync.HostLimits)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	run.ctx = ctx
+	if *traceRepo != "" {
+		if _, ok := repoSync.Repos[*traceRepo]; !ok {
+			log.Errorf("--trace-repo: repo '%s' isn't configured (or selected)", *traceRepo)
+			os.Exit(exitUsage)
+		}
+		tracer, err := openProtocolTracer(*traceRepoFile)
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(exitFailure)
+		}
+		installProtocolTracing()
+		run.traceRepo, run.tracer = *traceRepo, tracer
+		log.Infof("Tracing remote operations of '%s' to %s", *traceRepo, *traceRepoFile)
+	}
+
+	// exit - Exit with the code, flushing pending spans first (deferred calls don't run on os.Exit).
+	shutdownTracing := func() {}
+	exit := func(code int) {
+		shutdownTracing()
+		os.Exit(code)
+	}
+	if *traceFlag {
+		shutdownTracing, err = setupTracing(ctx)
+		if err != nil {
+			log.Errorf("failed to set up tracing: %v", err)
+			os.Exit(exitFailure)
+		}
+		defer shutdownTracing()
+	}
+	if *onlyBranchesFlag != "" {
+		run.onlyBranches = strings.Split(*onlyBranchesFlag, ",")
+	}
+
+	if *checkPush {
+		var checkErr error
+		for _, rs := range repoSync.Repos {
+			if err := checkPushAccess(rs, run); err != nil {
+				log.Errorf("%v", err)
+				checkErr = err
+				continue
+			}
+			log.Infof("repo '%s': push to %s allowed", rs.Name, rs.TargetRemote.Name)
+		}
+		if checkErr != nil {
+			exit(exitCode(checkErr))
+		}
+		return
+	}
+
+	if command != "" {
+		names := make([]string, 0, len(repoSync.Repos))
+		for name := range repoSync.Repos {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var cmdErr error
+		for _, name := range names {
+			rs := repoSync.Repos[name]
+			var lines []string
+			var err error
+			if command == "plan" {
+				var refSpecs []string
+				refSpecs, err = planRefSpecs(repoSync, rs, run)
+				for _, refSpec := range refSpecs {
+					lines = append(lines, fmt.Sprintf("%s %s", rs.TargetRemote.Name, refSpec))
 				}
 			} else {
-				log.Infof("Switching to branch %s in %s", localBranch.Name().Short(), rs.Path)
-				err = w.Checkout(&git.CheckoutOptions{
-					Branch: localBranch.Name(),
-					Create: false,
-					Force:  true,
-					Keep:   false,
-				})
-				if err != nil {
-					log.Errorf("failed to switch to %s in %s: %v", localBranch.Name().Short(), rs.Path, err)
-					os.Exit(1)
+				var branches []*plumbing.Reference
+				branches, err = listBranches(repoSync, rs, run)
+				for _, b := range branches {
+					lines = append(lines, fmt.Sprintf("%s %s", b.Name().Short(), b.Hash()))
 				}
 			}
-
-			log.Infof("Pulling %s from '%s' of %s", remoteBranch.Name().Short(), rs.SourceRemote.Name, rs.Path)
-			err = w.Pull(&git.PullOptions{
-				RemoteName:    rs.SourceRemote.Name,
-				ReferenceName: remoteBranch.Name(),
-				SingleBranch:  true,
-				Force:         true,
-			})
-			if err != nil && err != git.NoErrAlreadyUpToDate {
-				log.Errorf("failed to pull %s in %s: %v", remoteBranch.Name().Short(), rs.Path, err)
-				os.Exit(1)
+			if err != nil {
+				log.Errorf("%v", err)
+				cmdErr = err
+				continue
 			}
+			for _, line := range lines {
+				fmt.Printf("%s %s\n", name, line)
+			}
+		}
+		if cmdErr != nil {
+			exit(exitCode(cmdErr))
+		}
+		return
+	}
 
-			log.Infof("Reseting branch %s to %s", localBranch.Name().Short(), localBranch.Hash())
-			err = w.Reset(&git.ResetOptions{
-				Commit: localBranch.Hash(),
-				Mode:   git.HardReset,
-			})
+	if *diffOnly {
+		plan := &Plan{DiffOnly: true}
+		var diffErr error
+		drift := false
+		for _, rs := range repoSync.Repos {
+			repoPlan, err := diffRepo(repoSync, rs, run)
 			if err != nil {
-				log.Errorf("failed to reset branch %s in %s: %v", remoteBranch.Name().Short(), rs.Path, err)
-				os.Exit(1)
-			}
-
-			refSpecStr := fmt.Sprintf(
-				"+%s:refs/heads/%s",
-				localBranch.Name().String(),
-				repoSync.mapBranch(remoteBranch.Name().Short()),
-			)
-			refSpec := config.RefSpec(refSpecStr)
-			log.Infof("Pushing %s", refSpec)
-			err = repo.Push(&git.PushOptions{
-				RemoteName: rs.TargetRemote.Name,
-				Force:      true,
-				RefSpecs:   []config.RefSpec{refSpec},
-				Atomic:     true,
-			})
-			if err != nil {
-				if err == git.NoErrAlreadyUpToDate {
-					log.Infof("remote up to date - %s", refSpecStr)
-				} else {
-					log.Errorf("failed to push %s: %v", refSpecStr, err)
-					os.Exit(1)
-				}
+				log.Errorf("%v", err)
+				repoPlan.Error = err.Error()
+				diffErr = err
+			}
+			logDrift(repoPlan)
+			drift = drift || len(repoPlan.Branches)+len(repoPlan.Tags) > 0
+			plan.Repos = append(plan.Repos, repoPlan)
+		}
+		sort.Slice(plan.Repos, func(i, j int) bool { return plan.Repos[i].Name < plan.Repos[j].Name })
+		if *reportPath != "" {
+			if err := writePlan(*reportPath, plan); err != nil {
+				log.Errorf("%v", err)
+				exit(exitFailure)
 			}
+		}
+		if diffErr != nil {
+			exit(exitCode(diffErr))
+		}
+		if drift {
+			log.Errorf("Targets differ from sources")
+			exit(exitDrift)
+		}
+		return
+	}
 
-			status, err := w.Status()
+	if *dryRun {
+		plan := &Plan{DryRun: true}
+		var planErr error
+		for _, rs := range repoSync.Repos {
+			repoPlan, err := planRepo(repoSync, rs, run)
 			if err != nil {
-				log.Errorf("failed to get repo status: %v", err)
-				os.Exit(1)
-			} else {
-				log.Infof("Repository status: %v", status)
+				log.Errorf("%v", err)
+				repoPlan.Error = err.Error()
+				planErr = err
+			}
+			logPlan(repoPlan)
+			plan.Repos = append(plan.Repos, repoPlan)
+		}
+		sort.Slice(plan.Repos, func(i, j int) bool { return plan.Repos[i].Name < plan.Repos[j].Name })
+		if *reportPath != "" {
+			if err := writePlan(*reportPath, plan); err != nil {
+				log.Errorf("%v", err)
+				exit(exitFailure)
 			}
 		}
+		if planErr != nil {
+			exit(exitCode(planErr))
+		}
+		return
+	}
 
-		// Push all tags
-		tags, err := repo.Tags()
-		if err != nil {
-			log.Errorf("failed to get tags: %v", err)
-			os.Exit(1)
-		} else {
-			tags.ForEach(func(t *plumbing.Reference) error {
-				tagsRefSpec := fmt.Sprintf("+refs/tags/%s:refs/tags/%s", t.Name().Short(), t.Name().Short())
-				log.Infof("Pushing tag %s to %s with refspec %s", t.Name().Short(), rs.TargetRemote.Name, tagsRefSpec)
-				err = repo.Push(&git.PushOptions{
-					RemoteName: rs.TargetRemote.Name,
-					RefSpecs:   []config.RefSpec{config.RefSpec(tagsRefSpec)},
-					FollowTags: true,
-					Force:      true,
-				})
-				if err != nil {
-					if err == git.NoErrAlreadyUpToDate {
-						log.Infof("tag %s already up to date", t.Name().Short())
-					} else {
-						log.Errorf("failed to push tags: %v", err)
-						os.Exit(1)
-					}
+	var window *hoursWindow
+	if *interval > 0 && repoSync.AllowedHours != "" {
+		// Validated already.
+		window, _ = parseHoursWindow(repoSync.AllowedHours)
+	}
+
+	for cycle := 1; ; cycle++ {
+		if window != nil && !window.contains(time.Now()) {
+			next := window.nextStart(time.Now())
+			log.Infof("Outside allowed hours %s, waiting until %s", repoSync.AllowedHours, next.Format(time.RFC3339))
+			select {
+			case <-ctx.Done():
+				log.Infof("Stopping between sync cycles")
+				return
+			case <-time.After(time.Until(next)):
+			}
+		}
+
+		cycleCtx, span := tracer.Start(ctx, "sync", trace.WithAttributes(
+			attribute.Int("cycle", cycle),
+			attribute.Int("repos", len(repoSync.Repos)),
+		))
+		run.ctx = cycleCtx
+		results, syncErr := runCycle(repoSync, run, cycle)
+		if syncErr != nil {
+			span.RecordError(syncErr)
+			span.SetStatus(codes.Error, syncErr.Error())
+		}
+		span.End()
+		if err := run.state.save(); err != nil {
+			log.Errorf("%v", err)
+		}
+		logSummary(results)
+		if *outputFormat == outputGithubActions {
+			writeAnnotations(os.Stdout, results)
+		}
+
+		if *reportPath != "" {
+			if err := writeReport(*reportPath, results); err != nil {
+				log.Errorf("%v", err)
+				if *interval == 0 {
+					exit(exitFailure)
 				}
+			}
+		}
+		if repoSync.OnError != "" {
+			runOnError(repoSync.OnError, results)
+		}
+		if ctx.Err() != nil {
+			log.Errorf("Interrupted, summary above covers repos synced so far")
+			exit(exitInterrupted)
+		}
+		if syncErr != nil {
+			log.Errorf("%v", syncErr)
+			if *interval == 0 {
+				exit(exitCode(syncErr))
+			}
+		}
+		if *interval == 0 {
+			return
+		}
 
-				return nil
-			})
+		log.Infof("Sync cycle %d done, next one in %s", cycle, *interval)
+		select {
+		case <-ctx.Done():
+			log.Infof("Stopping between sync cycles")
+			return
+		case <-time.After(*interval):
 		}
 	}
 }
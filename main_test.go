@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestResolveDefaultBranch(t *testing.T) {
+	a := plumbing.NewHash("1111111111111111111111111111111111111111")
+	b := plumbing.NewHash("2222222222222222222222222222222222222222")
+	branch := plumbing.NewBranchReferenceName
+	cases := []struct {
+		name string
+		refs []*plumbing.Reference
+		want plumbing.ReferenceName
+	}{
+		{"HEAD symref", []*plumbing.Reference{
+			plumbing.NewSymbolicReference(plumbing.HEAD, branch("develop")),
+			plumbing.NewHashReference(branch("develop"), a),
+			plumbing.NewHashReference(branch("main"), b),
+		}, branch("develop")},
+		{"HEAD hash matching fallback", []*plumbing.Reference{
+			plumbing.NewHashReference(plumbing.HEAD, a),
+			plumbing.NewHashReference(branch("feature"), a),
+			plumbing.NewHashReference(branch("main"), a),
+			plumbing.NewHashReference(branch("master"), b),
+		}, branch("main")},
+		{"HEAD hash matching single branch", []*plumbing.Reference{
+			plumbing.NewHashReference(plumbing.HEAD, b),
+			plumbing.NewHashReference(branch("main"), a),
+			plumbing.NewHashReference(branch("trunk"), b),
+		}, branch("trunk")},
+		{"no HEAD", []*plumbing.Reference{
+			plumbing.NewHashReference(branch("develop"), a),
+			plumbing.NewHashReference(branch("master"), b),
+		}, branch("master")},
+		{"no HEAD nor fallbacks", []*plumbing.Reference{
+			plumbing.NewHashReference(branch("develop"), a),
+		}, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := resolveDefaultBranch(c.refs); got != c.want {
+				t.Errorf("expected '%s', got '%s'", c.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandTargetUrl(t *testing.T) {
+	template := "https://mirror.example.com/{org}/{name}.git"
+	cases := []struct {
+		template, source, want string
+		wantErr                bool
+	}{
+		{template, "https://github.com/acme/widget.git", "https://mirror.example.com/acme/widget.git", false},
+		{template, "git@gitlab.com:acme/tools/widget.git", "https://mirror.example.com/acme/tools/widget.git", false},
+		{template, "ssh://git@example.com:2222/acme/widget", "https://mirror.example.com/acme/widget.git", false},
+		{"ssh://git@mirror/{host}/{repo}", "https://Example.com:8443/acme/widget.git", "ssh://git@mirror/example.com:8443/widget-mirror", false},
+		{"https://{host}.mirror/{org}/{name}", "https://github.com/acme/widget", "https://github.com.mirror/acme/widget", false},
+		{template, "https://example.com/widget.git", "", true},
+		{"https://mirror.example.com/{owner}/{name}", "https://github.com/acme/widget.git", "", true},
+	}
+
+	for _, c := range cases {
+		got, err := expandTargetUrl(c.template, "widget-mirror", c.source)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s from %s: expected error, got '%s'", c.template, c.source, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s from %s: %v", c.template, c.source, err)
+		} else if got != c.want {
+			t.Errorf("%s from %s: expected '%s', got '%s'", c.template, c.source, c.want, got)
+		}
+	}
+}
+
+func TestMapBranch(t *testing.T) {
+	rs := &RepoSync{BranchMapping: map[string]string{
+		"master":           "main",
+		"release/*":        "rel/*",
+		"release/v1*":      "legacy/v1*",
+		"release/v1.0":     "stable",
+		"feature/*":        "feature/*",
+		"hotfix/*":         "main",
+		"deprecated/exact": "gone/exact",
+	}}
+	cases := []struct {
+		branch, want string
+	}{
+		{"master", "main"},
+		{"release/v1.0", "stable"},
+		{"release/v1.2", "legacy/v1.2"},
+		{"release/v2.0", "rel/v2.0"},
+		{"release/", "rel/"},
+		{"feature/x/y", "feature/x/y"},
+		{"develop", "develop"},
+		{"masters", "masters"},
+		{"deprecated/exact/more", "deprecated/exact/more"},
+	}
+
+	for _, c := range cases {
+		if got := rs.mapBranch(c.branch); got != c.want {
+			t.Errorf("%s: expected '%s', got '%s'", c.branch, c.want, got)
+		}
+	}
+}
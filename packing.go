@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/go-git/go-billy/v5"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// dotGitStorage - storage of a repo in a git directory on disk, as opened by go-git or tuned by openRepo.
+type dotGitStorage interface {
+	Filesystem() billy.Filesystem
+}
+
+// packWindowStorage - storage making go-git encode packs with the delta window instead of pack.window of the repo
+// config, which is left as it is on disk.
+type packWindowStorage struct {
+	*filesystem.Storage
+	window uint
+}
+
+func (s packWindowStorage) Config() (*config.Config, error) {
+	c, err := s.Storage.Config()
+	if err != nil {
+		return nil, err
+	}
+	tuned := *c
+	tuned.Pack.Window = s.window
+	return &tuned, nil
+}
+
+func (s packWindowStorage) SetConfig(c *config.Config) error {
+	onDisk, err := s.Storage.Config()
+	if err != nil {
+		return err
+	}
+	restored := *c
+	restored.Pack.Window = onDisk.Pack.Window
+	return s.Storage.SetConfig(&restored)
+}
+
+// tunesMemory - Whether any of the memory knobs of the repo is set.
+func (r *Repo) tunesMemory() bool {
+	return r.PackWindow != nil || r.ObjectCacheBytes > 0 || r.LargeObjectBytes > 0
+}
+
+// openRepo - Open the repo at its path, with storage tuned by PackWindow, ObjectCacheBytes and LargeObjectBytes when
+// any is set.
+func openRepo(rs *Repo) (*git.Repository, error) {
+	repo, err := git.PlainOpen(rs.Path)
+	if err != nil || !rs.tunesMemory() {
+		return repo, err
+	}
+
+	plain, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return repo, nil
+	}
+	var worktree billy.Filesystem
+	if w, err := repo.Worktree(); err == nil {
+		worktree = w.Filesystem
+	} else if err != git.ErrIsBareRepository {
+		return nil, err
+	}
+
+	cacheSize := cache.DefaultMaxSize
+	if rs.ObjectCacheBytes > 0 {
+		cacheSize = cache.FileSize(rs.ObjectCacheBytes)
+	}
+	tuned := filesystem.NewStorageWithOptions(plain.Filesystem(), cache.NewObjectLRU(cacheSize), filesystem.Options{
+		LargeObjectThreshold: int64(rs.LargeObjectBytes),
+	})
+	var storer storage.Storer = tuned
+	if rs.PackWindow != nil {
+		storer = packWindowStorage{Storage: tuned, window: *rs.PackWindow}
+	}
+
+	return git.Open(storer, worktree)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions of a dry-run plan, see RefAction.Action.
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+	actionReview = "review"
+)
+
+// RefAction - branch or tag the sync would create, update or delete on the target. Rewritten branches (subtreePrefix)
+// get pushed at a hash other than SourceHash.
+type RefAction struct {
+	Source     string `json:"source,omitempty"`
+	Target     string `json:"target"`
+	Action     string `json:"action"`
+	SourceHash string `json:"sourceHash,omitempty"`
+	TargetHash string `json:"targetHash,omitempty"`
+	Rewritten  bool   `json:"rewritten,omitempty"`
+}
+
+// RepoPlan - actions a sync of a single repository would take.
+type RepoPlan struct {
+	Name     string       `json:"name"`
+	Branches []*RefAction `json:"branches"`
+	Tags     []*RefAction `json:"tags,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// Plan - actions of a dry run, distinguished from a report of the run by DryRun marker, or drift found by --diff-only
+// marked DiffOnly.
+type Plan struct {
+	DryRun   bool        `json:"dryRun"`
+	DiffOnly bool        `json:"diffOnly,omitempty"`
+	Repos    []*RepoPlan `json:"repos"`
+}
+
+// remoteUrl - URL of the remote, the configured one, otherwise the one of the remote in the repo's working copy.
+func remoteUrl(rs *Repo, remote *Remote, configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	if rs.Path == "" {
+		return "", fmt.Errorf("remote %s of '%s' has no url", remote.Name, rs.Name)
+	}
+
+	repo, err := git.PlainOpen(rs.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repo from %s: %w", rs.Path, err)
+	}
+	r, err := repo.Remote(remote.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote %s of '%s': %w", remote.Name, rs.Path, err)
+	}
+
+	return r.Config().URLs[0], nil
+}
+
+// pushedRef - source ref, the target ref it's pushed to and refspec syncing pushes it with. Branches pushed to Gerrit
+// review refs (never advertised by the target) have the target branch their changes get submitted to in reviewed,
+// rewritten ones (subtreePrefix) are pushed with history rewritten, at hashes unknown until syncing.
+type pushedRef struct {
+	source    *plumbing.Reference
+	target    plumbing.ReferenceName
+	refSpec   config.RefSpec
+	reviewed  plumbing.ReferenceName
+	rewritten bool
+}
+
+// listRefsByName - List refs advertised by the remote of the repo at configured url (or url of the remote in the
+// working copy), keyed by their names. Working copy isn't touched.
+func listRefsByName(rs *Repo, remote *Remote, configured string, opts remoteOpts) (map[plumbing.ReferenceName]*plumbing.Reference, error) {
+	url, err := remoteUrl(rs, remote, configured)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := remote.auth()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up auth for %s of '%s': %w", remote.Name, rs.Name, err)
+	}
+
+	r := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: remote.Name, URLs: []string{url}})
+	refs, err := listRemoteRefs(r, auth, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs of %s (%s): %w", remote.Name, url, err)
+	}
+
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+	return byName, nil
+}
+
+// pushedRefs - Branches (mapped) and tags of source refs syncing the repo pushes, sorted by target ref. Filters
+// needing the history (skipCommitMarker, mergedInto, tagsSince) aren't applied.
+func pushedRefs(repoSync *RepoSync, rs *Repo, run *runOptions, sourceRefs map[plumbing.ReferenceName]*plumbing.Reference) ([]pushedRef, error) {
+	var headBranch plumbing.ReferenceName
+	if rs.DefaultBranchOnly {
+		var refs []*plumbing.Reference
+		for _, r := range sourceRefs {
+			refs = append(refs, r)
+		}
+		if headBranch = rs.sourceDefaultBranch(refs); headBranch == "" {
+			return nil, fmt.Errorf("failed to determine default branch of remote '%s' in repo '%s'", rs.SourceRemote.Name, rs.Name)
+		}
+	}
+
+	var pushed []pushedRef
+	for _, r := range sourceRefs {
+		if r.Name().IsTag() {
+			if rs.tagsEnabled() {
+				target := rs.targetRef(r.Name())
+				refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", r.Name(), target))
+				pushed = append(pushed, pushedRef{source: r, target: target, refSpec: refSpec})
+			}
+			continue
+		}
+
+		name, ok := rs.sourceBranch(r.Name())
+		if !rs.branchesEnabled() || !ok || (headBranch != "" && name != headBranch) ||
+			!rs.branchSelected(name.Short(), run.onlyBranches) {
+			continue
+		}
+		local := name
+		if rs.SubtreePrefix != "" {
+			local = subtreeRef(name)
+		}
+		refSpec, err := rs.branchPushSpec(local, repoSync.mapBranch(name.Short()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to make refspec pushing %s: %w", name.Short(), err)
+		}
+		p := pushedRef{source: r, target: refSpec.Dst(local), refSpec: refSpec, rewritten: rs.SubtreePrefix != ""}
+		if target := p.target.String(); rs.GerritReview && strings.HasPrefix(target, gerritReviewPrefix) {
+			p.reviewed = rs.targetRef(plumbing.NewBranchReferenceName(strings.TrimPrefix(target, gerritReviewPrefix)))
+		}
+		pushed = append(pushed, p)
+	}
+
+	sort.Slice(pushed, func(i, j int) bool { return pushed[i].target < pushed[j].target })
+	return pushed, nil
+}
+
+// listBranches - Source branches that are candidates for syncing the repo (after include/exclude filters), sorted by
+// name, listing only source refs.
+func listBranches(repoSync *RepoSync, rs *Repo, run *runOptions) ([]*plumbing.Reference, error) {
+	opts := remoteOpts{
+		ctx:     run.repoContext(rs),
+		retries: rs.retryCount(run.retries),
+		timeout: rs.timeout(run.opTimeout),
+		log:     log.WithField("repo", rs.Name),
+		hosts:   run.remoteHosts,
+	}
+
+	rs, err := availableSource(rs, opts)
+	if err != nil {
+		return nil, syncError(rs, err)
+	}
+	sourceRefs, err := listRefsByName(rs, rs.SourceRemote, rs.sourceUrl(), opts)
+	if err != nil {
+		return nil, syncError(rs, err)
+	}
+	pushed, err := pushedRefs(repoSync, rs, run, sourceRefs)
+	if err != nil {
+		return nil, syncError(rs, err)
+	}
+
+	var branches []*plumbing.Reference
+	for _, p := range pushed {
+		name, ok := rs.sourceBranch(p.source.Name())
+		if !ok {
+			continue
+		}
+		branches = append(branches, plumbing.NewHashReference(name, p.source.Hash()))
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name() < branches[j].Name() })
+	return branches, nil
+}
+
+// planRefSpecs - Refspecs syncing the repo would push to its target, sorted, listing only source refs.
+func planRefSpecs(repoSync *RepoSync, rs *Repo, run *runOptions) ([]string, error) {
+	opts := remoteOpts{
+		ctx:     run.repoContext(rs),
+		retries: rs.retryCount(run.retries),
+		timeout: rs.timeout(run.opTimeout),
+		log:     log.WithField("repo", rs.Name),
+		hosts:   run.remoteHosts,
+	}
+
+	rs, err := availableSource(rs, opts)
+	if err != nil {
+		return nil, syncError(rs, err)
+	}
+	sourceRefs, err := listRefsByName(rs, rs.SourceRemote, rs.sourceUrl(), opts)
+	if err != nil {
+		return nil, syncError(rs, err)
+	}
+	pushed, err := pushedRefs(repoSync, rs, run, sourceRefs)
+	if err != nil {
+		return nil, syncError(rs, err)
+	}
+
+	var refSpecs []string
+	for _, p := range pushed {
+		refSpecs = append(refSpecs, p.refSpec.String())
+	}
+	return refSpecs, nil
+}
+
+// planRepo - Compute branches and tags syncing the repo would push to (or delete from) the target, comparing refs
+// advertised by both remotes. Nothing is fetched or written; filters needing the history (skipCommitMarker,
+// mergedInto, tagsSince) aren't applied and extra refs aren't planned.
+func planRepo(repoSync *RepoSync, rs *Repo, run *runOptions) (*RepoPlan, error) {
+	return compareRefs(repoSync, rs, run, false)
+}
+
+// diffRepo - Compute drift of the target from the source of the repo: branches and tags missing on the target
+// (create), at a different hash (update) or extra on the target (delete), i.e. not pushed from any source ref. Same
+// limitations as planRepo apply.
+func diffRepo(repoSync *RepoSync, rs *Repo, run *runOptions) (*RepoPlan, error) {
+	diffRun := *run
+	diffRun.pruneTags = true
+	return compareRefs(repoSync, rs, &diffRun, true)
+}
+
+// compareRefs - Compare refs advertised by source and target of the repo, as planRepo does, or for drift when diff is
+// set: target branches not pushed from any source ref are listed for deletion as well, review refs, never advertised
+// by the target, aren't compared and rewritten branches only when missing on the target.
+func compareRefs(repoSync *RepoSync, rs *Repo, run *runOptions, diff bool) (*RepoPlan, error) {
+	plan := &RepoPlan{Name: rs.Name}
+	opts := remoteOpts{
+		ctx:     run.repoContext(rs),
+		retries: rs.retryCount(run.retries),
+		timeout: rs.timeout(run.opTimeout),
+		log:     log.WithField("repo", rs.Name),
+		hosts:   run.remoteHosts,
+	}
+
+	rs, err := availableSource(rs, opts)
+	if err != nil {
+		return plan, syncError(rs, err)
+	}
+
+	sourceRefs, err := listRefsByName(rs, rs.SourceRemote, rs.sourceUrl(), opts)
+	if err != nil {
+		return plan, syncError(rs, err)
+	}
+	targetRefs, err := listRefsByName(rs, rs.TargetRemote, rs.TargetRemote.Url, opts)
+	if err != nil {
+		return plan, syncError(rs, err)
+	}
+	pushed, err := pushedRefs(repoSync, rs, run, sourceRefs)
+	if err != nil {
+		return plan, syncError(rs, err)
+	}
+
+	for _, p := range pushed {
+		a := &RefAction{Source: p.source.Name().String(), Target: p.target.String(), SourceHash: p.source.Hash().String(), Rewritten: p.rewritten}
+		current, ok := targetRefs[p.target]
+		switch {
+		case p.reviewed != "" && diff, p.rewritten && diff && ok:
+			continue
+		case p.reviewed != "":
+			a.Action = actionReview
+		case !ok:
+			a.Action = actionCreate
+		case p.rewritten:
+			// The rewritten hash isn't known without rewriting, the update may turn out to be a no-op.
+			a.Action = actionUpdate
+			a.TargetHash = current.Hash().String()
+		case current.Hash() != p.source.Hash():
+			a.Action = actionUpdate
+			a.TargetHash = current.Hash().String()
+		default:
+			continue
+		}
+		if p.source.Name().IsTag() {
+			plan.Tags = append(plan.Tags, a)
+		} else {
+			plan.Branches = append(plan.Branches, a)
+		}
+	}
+
+	if diff && rs.branchesEnabled() {
+		pushedTargets := map[plumbing.ReferenceName]bool{}
+		for _, p := range pushed {
+			pushedTargets[p.target] = true
+			if p.reviewed != "" {
+				pushedTargets[p.reviewed] = true
+			}
+		}
+		for name, r := range targetRefs {
+			branch, ok := rs.sourceRef(name)
+			if ok && branch.IsBranch() && !pushedTargets[name] {
+				plan.Branches = append(plan.Branches, &RefAction{Target: name.String(), Action: actionDelete, TargetHash: r.Hash().String()})
+			}
+		}
+	}
+
+	if rs.tagsEnabled() && run.pruneTags {
+		for name, r := range targetRefs {
+			tag, ok := rs.sourceRef(name)
+			if _, onSource := sourceRefs[tag]; ok && tag.IsTag() && !onSource {
+				plan.Tags = append(plan.Tags, &RefAction{Target: name.String(), Action: actionDelete, TargetHash: r.Hash().String()})
+			}
+		}
+	}
+
+	sort.Slice(plan.Branches, func(i, j int) bool { return plan.Branches[i].Target < plan.Branches[j].Target })
+	sort.Slice(plan.Tags, func(i, j int) bool { return plan.Tags[i].Target < plan.Tags[j].Target })
+
+	return plan, nil
+}
+
+// logPlan - Log actions of the plan, one line each.
+func logPlan(plan *RepoPlan) {
+	for _, a := range append(append([]*RefAction{}, plan.Branches...), plan.Tags...) {
+		source := a.SourceHash
+		if a.Rewritten {
+			source = "rewritten " + source
+		}
+		switch a.Action {
+		case actionDelete:
+			log.Infof("repo '%s': would delete %s (%s)", plan.Name, a.Target, a.TargetHash)
+		case actionUpdate:
+			log.Infof("repo '%s': would update %s from %s to %s (%s)", plan.Name, a.Target, a.TargetHash, source, a.Source)
+		case actionReview:
+			log.Infof("repo '%s': would push %s for review at %s (%s)", plan.Name, a.Target, source, a.Source)
+		default:
+			log.Infof("repo '%s': would create %s at %s (%s)", plan.Name, a.Target, source, a.Source)
+		}
+	}
+	log.Infof("repo '%s': %d branches and %d tags to change", plan.Name, len(plan.Branches), len(plan.Tags))
+}
+
+// logDrift - Log drift of the repo's target from its source found by diffRepo, one line per ref.
+func logDrift(plan *RepoPlan) {
+	for _, a := range append(append([]*RefAction{}, plan.Branches...), plan.Tags...) {
+		switch a.Action {
+		case actionDelete:
+			log.Warnf("repo '%s': %s extra on target (%s)", plan.Name, a.Target, a.TargetHash)
+		case actionUpdate:
+			log.Warnf("repo '%s': %s differs, target at %s, source at %s (%s)", plan.Name, a.Target, a.TargetHash, a.SourceHash, a.Source)
+		default:
+			log.Warnf("repo '%s': %s missing on target, source at %s (%s)", plan.Name, a.Target, a.SourceHash, a.Source)
+		}
+	}
+	if len(plan.Branches)+len(plan.Tags) == 0 {
+		log.Infof("repo '%s': target in sync with source", plan.Name)
+	}
+}
+
+// writePlan - Write the dry-run plan as JSON to the file at path.
+func writePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %v", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan '%s': %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// testSourceRefs - Source refs listed by plans in tests: branches `master` and `develop`, tag `v1.0.0`.
+func testSourceRefs() map[plumbing.ReferenceName]*plumbing.Reference {
+	a := plumbing.NewHash("1111111111111111111111111111111111111111")
+	b := plumbing.NewHash("2222222222222222222222222222222222222222")
+	refs := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, r := range []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("master"), a),
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("develop"), b),
+		plumbing.NewHashReference(plumbing.NewTagReferenceName("v1.0.0"), a),
+	} {
+		refs[r.Name()] = r
+	}
+	return refs
+}
+
+func TestPushedRefs(t *testing.T) {
+	cases := []struct {
+		name string
+		rs   *Repo
+		want []string
+	}{
+		{"mapped branches and tags", &Repo{Name: "plain"}, []string{
+			"+refs/heads/develop:refs/heads/develop",
+			"+refs/heads/master:refs/heads/main",
+			"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+		}},
+		{"pushRefSpecTemplate", &Repo{Name: "template", PushRefSpecTemplate: "{force}{source}:refs/heads/mirror/{mapped}"}, []string{
+			"+refs/heads/develop:refs/heads/mirror/develop",
+			"+refs/heads/master:refs/heads/mirror/main",
+			"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+		}},
+		{"gerritReview", &Repo{Name: "gerrit", GerritReview: true}, []string{
+			"refs/heads/develop:refs/for/develop",
+			"refs/heads/master:refs/for/main",
+			"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+		}},
+	}
+
+	repoSync := &RepoSync{BranchMapping: map[string]string{"master": "main"}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pushed, err := pushedRefs(repoSync, c.rs, &runOptions{}, testSourceRefs())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range pushed {
+				if p.target != p.refSpec.Dst(p.source.Name()) {
+					t.Errorf("%s: target %s isn't destination of its refspec", p.source.Name(), p.target)
+				}
+				got = append(got, p.refSpec.String())
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("expected refspecs %v, got %v", c.want, got)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Errorf("expected refspec %s, got %s", c.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// TestCompareReviewRefs - Branches of gerritReview repos are planned as pushes for review and left out of drift, their
+// review refs are never advertised by the target.
+func TestCompareReviewRefs(t *testing.T) {
+	source, target := seedSource(t), emptyTarget(t)
+	rs := &Repo{
+		Name:         "review",
+		SourceRemote: &Remote{Name: "origin", Url: addMemRemote(t, "review-source", source.Storer, "")},
+		TargetRemote: &Remote{Name: "gerrit", Url: addMemRemote(t, "review-target", target.Storer, "")},
+		GerritReview: true,
+	}
+	repoSync := &RepoSync{Repos: map[string]*Repo{rs.Name: rs}}
+	if err := repoSync.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := planRepo(repoSync, rs, testRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Branches) != 2 {
+		t.Fatalf("expected 2 branches planned, got %d", len(plan.Branches))
+	}
+	for _, a := range plan.Branches {
+		if a.Action != actionReview || a.Target != "refs/for/"+plumbing.ReferenceName(a.Source).Short() {
+			t.Errorf("expected %s pushed for review, got %s of %s", a.Source, a.Action, a.Target)
+		}
+	}
+
+	drift, err := diffRepo(repoSync, rs, testRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift.Branches) != 0 {
+		t.Errorf("expected no branch drift, got %d", len(drift.Branches))
+	}
+	if len(drift.Tags) != 2 {
+		t.Errorf("expected 2 tags missing on target, got %d", len(drift.Tags))
+	}
+}
+
+// TestCompareRewrittenBranches - Branches of subtreePrefix repos are planned as pushed rewritten, and count as drift
+// only when missing on the target, their hashes there differ from the source ones.
+func TestCompareRewrittenBranches(t *testing.T) {
+	source := seedSource(t)
+	syncTags := false
+	rs := &Repo{
+		Name:          "subtree",
+		SourceRemote:  &Remote{Name: "origin", Url: addMemRemote(t, "subtree-source", source.Storer, "")},
+		TargetRemote:  &Remote{Name: "monorepo", Url: addMemRemote(t, "subtree-target", seedSource(t).Storer, "")},
+		SubtreePrefix: "libs/foo",
+		SyncTags:      &syncTags,
+	}
+	repoSync := &RepoSync{Repos: map[string]*Repo{rs.Name: rs}}
+	if err := repoSync.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	refSpecs, err := planRefSpecs(repoSync, rs, testRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"+refs/subtree/heads/develop:refs/heads/develop", "+refs/subtree/heads/master:refs/heads/master"}
+	if len(refSpecs) != len(want) || refSpecs[0] != want[0] || refSpecs[1] != want[1] {
+		t.Errorf("expected refspecs %v, got %v", want, refSpecs)
+	}
+
+	plan, err := planRepo(repoSync, rs, testRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Branches) != 2 {
+		t.Fatalf("expected 2 branches planned, got %d", len(plan.Branches))
+	}
+	for _, a := range plan.Branches {
+		if !a.Rewritten {
+			t.Errorf("expected %s planned as rewritten", a.Target)
+		}
+	}
+
+	drift, err := diffRepo(repoSync, rs, testRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift.Branches) != 0 {
+		t.Errorf("expected no drift of branches on the target, got %d", len(drift.Branches))
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Provider - struct for reading info about hosting provider API from input YAML.
+type Provider struct {
+	Type string `yaml:"type"`
+	// TokenFile, when set, takes precedence over TokenEnv.
+	TokenEnv  string `yaml:"tokenEnv,omitempty"`
+	TokenFile string `yaml:"tokenFile,omitempty"`
+	ApiUrl    string `yaml:"apiUrl,omitempty"`
+	// Releases mirrors release metadata of tags too (GitHub only), see mirrorReleases.
+	Releases bool `yaml:"releases,omitempty"`
+}
+
+// repoMetadata - repository metadata mirrored via provider API.
+type repoMetadata struct {
+	Description string `json:"description"`
+	Homepage    string `json:"homepage,omitempty"`
+}
+
+// providerClient - access to repository metadata of a hosting provider. Repositories are identified by their path on
+// the provider, e.g. `bar/foo`.
+type providerClient interface {
+	getMetadata(ctx context.Context, repoPath string) (*repoMetadata, error)
+	setMetadata(ctx context.Context, repoPath string, m *repoMetadata) error
+	setDefaultBranch(ctx context.Context, repoPath string, branchName string) error
+}
+
+// newProviderClient - Create API client for configured provider.
+func newProviderClient(p *Provider) (providerClient, error) {
+	token, err := readToken(p.TokenFile, p.TokenEnv)
+	if err != nil {
+		return nil, fmt.Errorf("no %s API token: %v", p.Type, err)
+	}
+
+	switch p.Type {
+	case "github":
+		apiUrl := p.ApiUrl
+		if apiUrl == "" {
+			apiUrl = "https://api.github.com"
+		}
+		return &githubClient{apiUrl: strings.TrimSuffix(apiUrl, "/"), token: token}, nil
+	case "gitlab":
+		apiUrl := p.ApiUrl
+		if apiUrl == "" {
+			apiUrl = "https://gitlab.com/api/v4"
+		}
+		return &gitlabClient{apiUrl: strings.TrimSuffix(apiUrl, "/"), token: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider type '%s'", p.Type)
+	}
+}
+
+// providerRepoPath - Return path of the repository on the provider derived from remote URL, e.g. `bar/foo` for
+// `git@github.com:bar/foo.git`.
+func providerRepoPath(remoteUrl string) (string, error) {
+	endpoint, err := transport.NewEndpoint(remoteUrl)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.Trim(endpoint.Path, "/"), ".git"), nil
+}
+
+// apiTimeout - time limit of a single API request (provider ones, afterPush), so a hung API can't block syncing of the
+// repo.
+const apiTimeout = time.Minute
+
+// apiClient - HTTP client of API requests, provider ones and afterPush.
+var apiClient = &http.Client{Timeout: apiTimeout}
+
+// doJSON - Send API request with JSON body (when in is not nil) and decode JSON response into out (when not nil).
+// The request is canceled with ctx, or after apiTimeout.
+func doJSON(ctx context.Context, method string, url string, headers map[string]string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// githubClient - providerClient for GitHub REST API.
+type githubClient struct {
+	apiUrl string
+	token  string
+}
+
+func (c *githubClient) headers() map[string]string {
+	return map[string]string{
+		"Authorization": "Bearer " + c.token,
+		"Accept":        "application/vnd.github+json",
+	}
+}
+
+func (c *githubClient) getMetadata(ctx context.Context, repoPath string) (*repoMetadata, error) {
+	m := &repoMetadata{}
+	err := doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/repos/%s", c.apiUrl, repoPath), c.headers(), nil, m)
+
+	return m, err
+}
+
+func (c *githubClient) setMetadata(ctx context.Context, repoPath string, m *repoMetadata) error {
+	return doJSON(ctx, http.MethodPatch, fmt.Sprintf("%s/repos/%s", c.apiUrl, repoPath), c.headers(), m, nil)
+}
+
+func (c *githubClient) setDefaultBranch(ctx context.Context, repoPath string, branchName string) error {
+	body := map[string]string{"default_branch": branchName}
+
+	return doJSON(ctx, http.MethodPatch, fmt.Sprintf("%s/repos/%s", c.apiUrl, repoPath), c.headers(), body, nil)
+}
+
+// gitlabClient - providerClient for GitLab REST API. GitLab projects have no homepage, only description is mirrored.
+type gitlabClient struct {
+	apiUrl string
+	token  string
+}
+
+func (c *gitlabClient) headers() map[string]string {
+	return map[string]string{"PRIVATE-TOKEN": c.token}
+}
+
+func (c *gitlabClient) getMetadata(ctx context.Context, repoPath string) (*repoMetadata, error) {
+	m := &repoMetadata{}
+	err := doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/projects/%s", c.apiUrl, url.PathEscape(repoPath)), c.headers(), nil, m)
+	m.Homepage = ""
+
+	return m, err
+}
+
+func (c *gitlabClient) setMetadata(ctx context.Context, repoPath string, m *repoMetadata) error {
+	body := map[string]string{"description": m.Description}
+
+	return doJSON(ctx, http.MethodPut, fmt.Sprintf("%s/projects/%s", c.apiUrl, url.PathEscape(repoPath)), c.headers(), body, nil)
+}
+
+func (c *gitlabClient) setDefaultBranch(ctx context.Context, repoPath string, branchName string) error {
+	body := map[string]string{"default_branch": branchName}
+
+	return doJSON(ctx, http.MethodPut, fmt.Sprintf("%s/projects/%s", c.apiUrl, url.PathEscape(repoPath)), c.headers(), body, nil)
+}
+
+// setProviderDefaultBranch - Set default branch of the repository at remote URL via provider API.
+func setProviderDefaultBranch(ctx context.Context, p *Provider, remoteUrl string, branchName string) error {
+	client, err := newProviderClient(p)
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := providerRepoPath(remoteUrl)
+	if err != nil {
+		return err
+	}
+
+	return client.setDefaultBranch(ctx, repoPath, branchName)
+}
+
+// mirrorMetadata - Copy description (and homepage where supported) of the source repository to the target one.
+func mirrorMetadata(ctx context.Context, p *Provider, sourceUrl string, targetUrl string) error {
+	client, err := newProviderClient(p)
+	if err != nil {
+		return err
+	}
+
+	sourcePath, err := providerRepoPath(sourceUrl)
+	if err != nil {
+		return err
+	}
+	targetPath, err := providerRepoPath(targetUrl)
+	if err != nil {
+		return err
+	}
+
+	m, err := client.getMetadata(ctx, sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of %s: %v", sourcePath, err)
+	}
+	current, err := client.getMetadata(ctx, targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata of %s: %v", targetPath, err)
+	}
+	if *current == *m {
+		return nil
+	}
+
+	if err := client.setMetadata(ctx, targetPath, m); err != nil {
+		return fmt.Errorf("failed to update metadata of %s: %v", targetPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkPushAccess - Check that the target remote of the repo accepts pushes with configured credentials, without
+// writing anything to it. Remote hosts refuse to start a receive-pack session (the server side of a push) for clients
+// without write access, so the session is opened and closed right after the refs advertisement.
+func checkPushAccess(rs *Repo, run *runOptions) error {
+	url, err := remoteUrl(rs, rs.TargetRemote, rs.TargetRemote.Url)
+	if err != nil {
+		return syncError(rs, err)
+	}
+
+	auth, err := rs.TargetRemote.auth()
+	if err != nil {
+		return syncError(rs, fmt.Errorf("failed to set up auth for %s of '%s': %w", rs.TargetRemote.Name, rs.Path, err))
+	}
+
+	opts := remoteOpts{
+		ctx:     run.repoContext(rs),
+		retries: rs.retryCount(run.retries),
+		timeout: rs.timeout(run.opTimeout),
+		log:     log.NewEntry(log.StandardLogger()),
+		hosts:   run.remoteHosts,
+	}
+	ep, ar, err := receivePackAdvertisement(url, auth, opts, fmt.Sprintf("check push access to %s", rs.TargetRemote.Name))
+	if err != nil {
+		return syncError(rs, fmt.Errorf("push to %s (%s) not allowed: %w", rs.TargetRemote.Name, url, err))
+	}
+	if ar != nil && ar.Capabilities.Supports(capability.PushCert) {
+		log.Infof("repo '%s': %s accepts signed pushes, if it requires them pushes will be rejected - they're sent unsigned", rs.Name, rs.TargetRemote.Name)
+	}
+
+	// Local receive-pack runs in-process and never checks permissions.
+	if ep.Protocol == "file" {
+		if err := checkWritable(ep.Path); err != nil {
+			return syncError(rs, fmt.Errorf("push to %s (%s) not allowed: %w", rs.TargetRemote.Name, url, err))
+		}
+	}
+
+	return nil
+}
+
+// receivePackAdvertisement - Open a receive-pack session (the server side of a push) with the remote at url and return
+// its endpoint and advertised refs and capabilities, nil advertisement for an empty remote.
+func receivePackAdvertisement(url string, auth transport.AuthMethod, opts remoteOpts, what string) (*transport.Endpoint, *packp.AdvRefs, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid remote url %s: %w", url, err)
+	}
+	cli, err := client.NewClient(ep)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported remote url %s: %w", url, err)
+	}
+
+	var ar *packp.AdvRefs
+	err = opts.towards(url).run(what, func(ctx context.Context) error {
+		session, err := cli.NewReceivePackSession(ep, auth)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+
+		ar, err = session.AdvertisedReferencesContext(ctx)
+		if err == transport.ErrEmptyRemoteRepository {
+			return nil
+		}
+		return err
+	})
+
+	return ep, ar, err
+}
+
+// signedPushHint - Point out that the target may require signed pushes when it rejected a push and advertises
+// push-cert capability. go-git can't sign pushes, so such targets can't be mirrored into.
+func signedPushHint(err error, remote *git.Remote, auth transport.AuthMethod, opts remoteOpts) error {
+	if errorKind(err) != ErrPushRejected {
+		return err
+	}
+
+	_, ar, advErr := receivePackAdvertisement(remote.Config().URLs[0], auth, opts, fmt.Sprintf("check push-cert of %s", remote.Config().Name))
+	if advErr != nil || ar == nil || !ar.Capabilities.Supports(capability.PushCert) {
+		return err
+	}
+
+	return fmt.Errorf("%w (%s accepts signed pushes and may require them, signing pushes isn't supported)", err, remote.Config().Name)
+}
+
+// checkWritable - Check that objects can be written to the local repo at path, bare or not.
+func checkWritable(path string) error {
+	objects := filepath.Join(path, "objects")
+	if _, err := os.Stat(objects); err != nil {
+		objects = filepath.Join(path, ".git", "objects")
+	}
+
+	f, err := os.CreateTemp(objects, "push-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// pushEstimator - estimator of sizes of pushes of the repo to its target. Objects the target has are walked once per
+// repo (on the first estimate) and extended with the ones pushed, so estimates only walk objects new to the target.
+type pushEstimator struct {
+	repo *git.Repository
+	// haves - tips the target has, not walked yet.
+	haves []plumbing.Hash
+	// has - objects the target has (as far as known locally), nil until haves are walked.
+	has map[plumbing.Hash]bool
+}
+
+// newPushEstimator - Create estimator of pushes to a target having haves (missing ones are ignored).
+func newPushEstimator(repo *git.Repository, haves []plumbing.Hash) *pushEstimator {
+	return &pushEstimator{repo: repo, haves: haves}
+}
+
+// pushed - Record that the target has the object, and all reachable from it, after pushing it.
+func (e *pushEstimator) pushed(h plumbing.Hash) error {
+	if e.has == nil {
+		e.haves = append(e.haves, h)
+		return nil
+	}
+	return e.walk(h, e.has, true, nil)
+}
+
+// pushSize - Estimate number of objects a push of want would transfer, and their uncompressed size in bytes when
+// countBytes is set.
+func (e *pushEstimator) pushSize(want plumbing.Hash, countBytes bool) (int, int64, error) {
+	if e.has == nil {
+		e.has = map[plumbing.Hash]bool{}
+		for _, h := range e.haves {
+			if err := e.walk(h, e.has, true, nil); err != nil {
+				return 0, 0, err
+			}
+		}
+		e.haves = nil
+	}
+
+	objects := 0
+	var size int64
+	seen := map[plumbing.Hash]bool{}
+	err := e.walk(want, seen, false, func(o plumbing.EncodedObject) {
+		objects++
+		size += o.Size()
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if !countBytes {
+		size = 0
+	}
+	return objects, size, nil
+}
+
+// walk - Walk objects reachable from the hash (commits, their trees and blobs, targets of tags), skipping ones the
+// target has and ones in seen, adding walked ones to seen. Missing objects fail the walk unless allowMissing is set.
+func (e *pushEstimator) walk(from plumbing.Hash, seen map[plumbing.Hash]bool, allowMissing bool, visit func(plumbing.EncodedObject)) error {
+	pending := []plumbing.Hash{from}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] || e.has[h] {
+			continue
+		}
+		seen[h] = true
+
+		o, err := e.repo.Storer.EncodedObject(plumbing.AnyObject, h)
+		if errors.Is(err, plumbing.ErrObjectNotFound) && allowMissing {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("object %s: %w", h, err)
+		}
+		if visit != nil {
+			visit(o)
+		}
+
+		switch o.Type() {
+		case plumbing.CommitObject:
+			c, err := object.DecodeCommit(e.repo.Storer, o)
+			if err != nil {
+				return err
+			}
+			pending = append(append(pending, c.TreeHash), c.ParentHashes...)
+		case plumbing.TreeObject:
+			t, err := object.DecodeTree(e.repo.Storer, o)
+			if err != nil {
+				return err
+			}
+			for _, entry := range t.Entries {
+				if entry.Mode != filemode.Submodule {
+					pending = append(pending, entry.Hash)
+				}
+			}
+		case plumbing.TagObject:
+			t, err := object.DecodeTag(e.repo.Storer, o)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, t.Target)
+		}
+	}
+	return nil
+}
+
+// checkPushSize - Fail when pushing the ref to the target would exceed maxPushObjects or maxPushBytes of the repo.
+// Nothing is estimated when neither is set.
+func (r *Repo) checkPushSize(e *pushEstimator, name plumbing.ReferenceName) error {
+	if r.MaxPushObjects <= 0 && r.MaxPushBytes <= 0 {
+		return nil
+	}
+
+	ref, err := e.repo.Reference(name, true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	objects, size, err := e.pushSize(ref.Hash(), r.MaxPushBytes > 0)
+	if err != nil {
+		return fmt.Errorf("failed to estimate size of pushing %s: %w", name.Short(), err)
+	}
+	if r.MaxPushObjects > 0 && objects > r.MaxPushObjects {
+		return fmt.Errorf("pushing %s would transfer %d objects, over maxPushObjects %d (pass --allow-large-push if intended)",
+			name.Short(), objects, r.MaxPushObjects)
+	}
+	if r.MaxPushBytes > 0 && size > int64(r.MaxPushBytes) {
+		return fmt.Errorf("pushing %s would transfer %d bytes (uncompressed), over maxPushBytes %d (pass --allow-large-push if intended)",
+			name.Short(), size, r.MaxPushBytes)
+	}
+
+	return nil
+}
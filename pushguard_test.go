@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// TestPushSize - Estimates count only objects new to the target, including ones pushed by earlier estimated pushes.
+func TestPushSize(t *testing.T) {
+	repo := seedSource(t)
+	master := refHash(repo, plumbing.NewBranchReferenceName("master"))
+	develop := refHash(repo, plumbing.NewBranchReferenceName("develop"))
+	tag := refHash(repo, plumbing.NewTagReferenceName("v1.1.0"))
+
+	// Missing haves, e.g. target branches unknown locally, are ignored.
+	e := newPushEstimator(repo, []plumbing.Hash{plumbing.NewHash("1111111111111111111111111111111111111111")})
+	steps := []struct {
+		name string
+		want plumbing.Hash
+		// objects - commit, tree and blob per commit, tag object of annotated tags.
+		objects int
+	}{
+		{"master", master, 3},
+		{"develop", develop, 3},
+		{"v1.1.0", tag, 1},
+		{"master again", master, 0},
+	}
+	for _, s := range steps {
+		objects, size, err := e.pushSize(s.want, true)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if objects != s.objects {
+			t.Errorf("%s: expected %d objects, got %d", s.name, s.objects, objects)
+		}
+		if objects > 0 && size <= 0 {
+			t.Errorf("%s: expected size of %d objects, got %d", s.name, objects, size)
+		}
+		if err := e.pushed(s.want); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+	}
+
+	// Target having master already gets just develop's commit.
+	objects, _, err := newPushEstimator(repo, []plumbing.Hash{master}).pushSize(develop, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objects != 3 {
+		t.Errorf("develop onto master: expected 3 objects, got %d", objects)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// releasesPerPage - Page size of listing GitHub releases, the API maximum.
+const releasesPerPage = 100
+
+// githubRelease - release metadata mirrored via GitHub API, keyed by its tag.
+type githubRelease struct {
+	Id         int64  `json:"id,omitempty"`
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// listReleases - List all releases of the repository, following pages.
+func (c *githubClient) listReleases(ctx context.Context, repoPath string) ([]*githubRelease, error) {
+	var all []*githubRelease
+	for page := 1; ; page++ {
+		var releases []*githubRelease
+		u := fmt.Sprintf("%s/repos/%s/releases?per_page=%d&page=%d", c.apiUrl, repoPath, releasesPerPage, page)
+		if err := doJSON(ctx, http.MethodGet, u, c.headers(), nil, &releases); err != nil {
+			return nil, err
+		}
+		all = append(all, releases...)
+		if len(releases) < releasesPerPage {
+			return all, nil
+		}
+	}
+}
+
+// saveRelease - Create the release, or update the existing one with id.
+func (c *githubClient) saveRelease(ctx context.Context, repoPath string, id int64, r *githubRelease) error {
+	body := map[string]interface{}{"tag_name": r.TagName, "name": r.Name, "body": r.Body, "prerelease": r.Prerelease}
+	if id == 0 {
+		return doJSON(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/releases", c.apiUrl, repoPath), c.headers(), body, nil)
+	}
+
+	return doJSON(ctx, http.MethodPatch, fmt.Sprintf("%s/repos/%s/releases/%d", c.apiUrl, repoPath, id), c.headers(), body, nil)
+}
+
+// mirrorReleases - Copy name, notes and prerelease flag of published source releases to target releases of the same
+// tags, creating missing ones. Only releases of tags present on the target (in tags) are mirrored, so GitHub doesn't
+// create tags of its own for them. Assets aren't copied.
+func mirrorReleases(ctx context.Context, p *Provider, sourceUrl, targetUrl string, tags map[string]bool, logger *log.Entry) error {
+	client, err := newProviderClient(p)
+	if err != nil {
+		return err
+	}
+	github, ok := client.(*githubClient)
+	if !ok {
+		return fmt.Errorf("releases can only be mirrored from GitHub")
+	}
+
+	sourcePath, err := providerRepoPath(sourceUrl)
+	if err != nil {
+		return err
+	}
+	targetPath, err := providerRepoPath(targetUrl)
+	if err != nil {
+		return err
+	}
+
+	sourceReleases, err := github.listReleases(ctx, sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to list releases of %s: %v", sourcePath, err)
+	}
+	targetReleases, err := github.listReleases(ctx, targetPath)
+	if err != nil {
+		return fmt.Errorf("failed to list releases of %s: %v", targetPath, err)
+	}
+	byTag := map[string]*githubRelease{}
+	for _, r := range targetReleases {
+		byTag[r.TagName] = r
+	}
+
+	for _, r := range sourceReleases {
+		if r.Draft || !tags[r.TagName] {
+			continue
+		}
+		current := byTag[r.TagName]
+		if current != nil && current.Name == r.Name && current.Body == r.Body && current.Prerelease == r.Prerelease {
+			continue
+		}
+
+		var id int64
+		if current != nil {
+			id = current.Id
+		}
+		if err := github.saveRelease(ctx, targetPath, id, r); err != nil {
+			return fmt.Errorf("failed to mirror release %s to %s: %v", r.TagName, targetPath, err)
+		}
+		logger.Infof("Mirrored release %s to %s", r.TagName, targetPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// retryBackoff - Wait before the first retry of a failed operation, doubled with each next attempt.
+const retryBackoff = 2 * time.Second
+
+// withRetry - Run op, retrying it up to retries times with exponential backoff when it fails with a retryable error
+// (see isRetryable), until ctx is canceled. NoErrAlreadyUpToDate isn't considered a failure and is returned right away.
+func withRetry(ctx context.Context, logger *log.Entry, retries int, what string, op func() error) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || err == git.NoErrAlreadyUpToDate || attempt >= retries || ctx.Err() != nil ||
+			!isRetryable(err) {
+			return err
+		}
+
+		logger.Warnf("failed to %s (attempt %d of %d), retrying in %s: %v", what, attempt+1, retries+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// hostSlots - Semaphores limiting remote operations running at once per server (across all repos), created on first
+// use of each host with its size from limits, or the default size for hosts not listed there.
+type hostSlots struct {
+	mu     sync.Mutex
+	size   int
+	limits map[string]int
+	byHost map[string]chan struct{}
+}
+
+// newHostSlots - Semaphores of size per host, overridden by limits keyed by host as remoteHost returns it. Hosts not
+// listed in limits are unlimited when size is 0.
+func newHostSlots(size int, limits map[string]int) *hostSlots {
+	return &hostSlots{size: size, limits: limits, byHost: map[string]chan struct{}{}}
+}
+
+// forUrl - Semaphore of the server of the remote url, nil (unlimited) without limits.
+func (h *hostSlots) forUrl(url string) chan struct{} {
+	if h == nil {
+		return nil
+	}
+
+	host := remoteHost(url)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	slots, ok := h.byHost[host]
+	if !ok {
+		size, listed := h.limits[host]
+		if !listed {
+			size = h.size
+		}
+		if size > 0 {
+			slots = make(chan struct{}, size)
+		}
+		h.byHost[host] = slots
+	}
+	return slots
+}
+
+// remoteHost - Server of the remote url (host and port when not default), so repos sharing it share limits. Local
+// paths are all one "server", unparsable urls each their own.
+func remoteHost(url string) string {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return url
+	}
+	if ep.Protocol == "file" {
+		return ""
+	}
+
+	host := strings.ToLower(ep.Host)
+	if ep.Port != 0 {
+		host = fmt.Sprintf("%s:%d", host, ep.Port)
+	}
+	return host
+}
+
+// remoteOpts - retry and timeout settings applied to remote operations of a repo, and logger of the repo.
+type remoteOpts struct {
+	ctx     context.Context
+	retries int
+	timeout time.Duration
+	log     *log.Entry
+	// hosts, when set, limits remote operations running at once per server across all repos.
+	hosts *hostSlots
+	// slots limits operations with the server set by towards, unlimited when nil.
+	slots chan struct{}
+}
+
+// towards - Options of operations with the remote at url, limited by slots of its server.
+func (o remoteOpts) towards(url string) remoteOpts {
+	o.slots = o.hosts.forUrl(url)
+	return o
+}
+
+// run - Run remote operation op, each attempt limited by the timeout (when set), retrying failures with backoff. All
+// attempts are canceled with ctx of the options.
+func (o remoteOpts) run(what string, op func(ctx context.Context) error) (err error) {
+	spanCtx, span := startRemoteSpan(o.ctx, what)
+	defer func() {
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	return withRetry(o.ctx, o.log, o.retries, what, func() error {
+		if o.slots != nil {
+			select {
+			case o.slots <- struct{}{}:
+				defer func() { <-o.slots }()
+			case <-o.ctx.Done():
+				return o.ctx.Err()
+			}
+		}
+
+		ctx := spanCtx
+		if o.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, o.timeout)
+			defer cancel()
+		}
+
+		return op(ctx)
+	})
+}
+
+// listRemoteRefs - List refs advertised by the remote. Empty remote (e.g. freshly created repository) yields no refs
+// instead of an error.
+func listRemoteRefs(remote *git.Remote, auth transport.AuthMethod, opts remoteOpts) ([]*plumbing.Reference, error) {
+	var refs []*plumbing.Reference
+	opts = opts.towards(remote.Config().URLs[0])
+	err := opts.run(fmt.Sprintf("list %s", remote.Config().Name), func(ctx context.Context) error {
+		var err error
+		refs, err = remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+		if err == transport.ErrEmptyRemoteRepository {
+			return nil
+		}
+		return err
+	})
+
+	return refs, err
+}
+
+// availableSource - Return the repo with the first of its source remotes responding to listing refs as the source
+// remote, the repo itself when it has only one (or none responds, along with the error). Unavailable ones are skipped
+// right away, without retrying.
+func availableSource(rs *Repo, opts remoteOpts) (*Repo, error) {
+	if len(rs.SourceRemotes) <= 1 {
+		return rs, nil
+	}
+
+	probe := opts
+	probe.retries = 0
+	var errs []string
+	for _, source := range rs.SourceRemotes {
+		url, err := remoteUrl(rs, source, source.Url)
+		if err == nil {
+			var auth transport.AuthMethod
+			if auth, err = source.auth(); err == nil {
+				remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: source.Name, URLs: []string{url}})
+				_, err = listRemoteRefs(remote, auth, probe)
+			}
+		}
+		if err != nil {
+			opts.log.Warnf("Source remote %s of '%s' unavailable: %v", source.Name, rs.Name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", source.Name, err))
+			continue
+		}
+
+		opts.log.Infof("Syncing '%s' from source remote %s", rs.Name, source.Name)
+		if source == rs.SourceRemote {
+			return rs, nil
+		}
+		selected := *rs
+		selected.SourceRemote = source
+		return &selected, nil
+	}
+
+	return rs, fmt.Errorf("no source remote available: %s", strings.Join(errs, "; "))
+}
+
+// fetchRemote - Fetch from the remote, treating an empty remote as there being nothing to fetch.
+func fetchRemote(ctx context.Context, remote *git.Remote, o *git.FetchOptions) error {
+	err := remote.FetchContext(ctx, o)
+	if err == transport.ErrEmptyRemoteRepository {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	return err
+}
+
+// fetchIncrementally - Fetch remote branches one by one, so objects and refs of every completed branch are kept when
+// the fetch gets interrupted and the next attempt only negotiates what's still missing. Branches are refs matched by
+// refSpecs, or by fetch refspecs of the remote when nil.
+func fetchIncrementally(remote *git.Remote, auth transport.AuthMethod, tagMode git.TagMode, refSpecs []config.RefSpec, opts remoteOpts) error {
+	remoteRefs, err := listRemoteRefs(remote, auth, opts)
+	if err != nil {
+		return err
+	}
+	if refSpecs == nil {
+		refSpecs = remote.Config().Fetch
+	}
+	opts = opts.towards(remote.Config().URLs[0])
+
+	for _, r := range remoteRefs {
+		if r.Name().IsTag() || r.Name() == plumbing.HEAD {
+			continue
+		}
+
+		for _, fs := range refSpecs {
+			if !fs.Match(r.Name()) {
+				continue
+			}
+
+			refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", r.Name(), fs.Dst(r.Name())))
+			opts.log.Infof("Fetching %s from '%s'", refSpec, remote.Config().Name)
+			err = opts.run(fmt.Sprintf("fetch %s from %s", r.Name().Short(), remote.Config().Name), func(ctx context.Context) error {
+				return remote.FetchContext(ctx, &git.FetchOptions{
+					RefSpecs: []config.RefSpec{refSpec},
+					Tags:     git.NoTags,
+					Auth:     auth,
+				})
+			})
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				return err
+			}
+			break
+		}
+	}
+
+	return opts.run(fmt.Sprintf("fetch %s", remote.Config().Name), func(ctx context.Context) error {
+		return fetchRemote(ctx, remote, &git.FetchOptions{RefSpecs: refSpecs, Tags: tagMode, Auth: auth})
+	})
+}
+
+// cloneToTemp - Clone source remote of the repo into a new temporary directory, for repos without a persistent path.
+// Worktree isn't checked out, syncing checks out branches as needed.
+func cloneToTemp(rs *Repo, auth transport.AuthMethod, opts remoteOpts) (string, error) {
+	var dir string
+	opts = opts.towards(rs.sourceUrl())
+	err := opts.run(fmt.Sprintf("clone %s", rs.SourceRemote.Name), func(ctx context.Context) error {
+		var err error
+		dir, err = os.MkdirTemp("", "go-repo-sync-")
+		if err != nil {
+			return err
+		}
+
+		opts.log.Infof("Cloning %s of '%s' into %s", rs.sourceUrl(), rs.Name, dir)
+		_, err = git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+			URL:        rs.sourceUrl(),
+			RemoteName: rs.SourceRemote.Name,
+			Auth:       auth,
+			NoCheckout: true,
+		})
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+		return err
+	})
+
+	return dir, err
+}
+
+// removePartialPacks - Remove temporary packfiles left behind by interrupted fetches. go-git can't resume downloading
+// a partial packfile, so they only waste disk space.
+func removePartialPacks(repo *git.Repository, logger *log.Entry) error {
+	storage, ok := repo.Storer.(dotGitStorage)
+	if !ok {
+		return nil
+	}
+
+	fs := storage.Filesystem()
+	packDir := fs.Join("objects", "pack")
+	files, err := fs.ReadDir(packDir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), "tmp_pack_") {
+			continue
+		}
+		logger.Infof("Removing partial packfile %s of an interrupted fetch", f.Name())
+		if err := fs.Remove(fs.Join(packDir, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
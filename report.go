@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Outcomes of pushing a branch or tag, see BranchResult.Outcome and TagResult.Outcome.
+const (
+	outcomeUpdated  = "updated"
+	outcomeUpToDate = "uptodate"
+	outcomeDeleted  = "deleted"
+	outcomeFailed   = "failed"
+)
+
+// BranchResult - outcome of syncing a single branch.
+type BranchResult struct {
+	Branch    string `json:"branch"`
+	Target    string `json:"target"`
+	Hash      string `json:"hash"`
+	OldHash   string `json:"oldHash,omitempty"`
+	NewBranch bool   `json:"newBranch"`
+	Commits   int    `json:"commits"`
+	Outcome   string `json:"outcome,omitempty"`
+	Skipped   string `json:"skipped,omitempty"`
+}
+
+// TagResult - outcome of syncing a single tag.
+type TagResult struct {
+	Tag     string `json:"tag"`
+	Hash    string `json:"hash"`
+	OldHash string `json:"oldHash,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// outcomeCounts - numbers of updated and up to date branches and tags.
+type outcomeCounts struct {
+	BranchesUpdated  int `json:"branchesUpdated"`
+	BranchesUpToDate int `json:"branchesUpToDate"`
+	TagsUpdated      int `json:"tagsUpdated"`
+	TagsUpToDate     int `json:"tagsUpToDate"`
+	TagsDeleted      int `json:"tagsDeleted"`
+}
+
+// add - Add counts of other to c.
+func (c *outcomeCounts) add(other outcomeCounts) {
+	c.BranchesUpdated += other.BranchesUpdated
+	c.BranchesUpToDate += other.BranchesUpToDate
+	c.TagsUpdated += other.TagsUpdated
+	c.TagsUpToDate += other.TagsUpToDate
+	c.TagsDeleted += other.TagsDeleted
+}
+
+// RepoResult - outcome of syncing a single repository.
+type RepoResult struct {
+	Name     string          `json:"name"`
+	Branches []*BranchResult `json:"branches"`
+	Tags     []*TagResult    `json:"tags,omitempty"`
+	Counts   outcomeCounts   `json:"counts"`
+	Skipped  string          `json:"skipped,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// tally - Count outcomes of branches and tags of the repo into Counts.
+func (r *RepoResult) tally() {
+	r.Counts = outcomeCounts{}
+	for _, b := range r.Branches {
+		switch b.Outcome {
+		case outcomeUpdated:
+			r.Counts.BranchesUpdated++
+		case outcomeUpToDate:
+			r.Counts.BranchesUpToDate++
+		}
+	}
+	for _, t := range r.Tags {
+		switch t.Outcome {
+		case outcomeUpdated:
+			r.Counts.TagsUpdated++
+		case outcomeUpToDate:
+			r.Counts.TagsUpToDate++
+		case outcomeDeleted:
+			r.Counts.TagsDeleted++
+		}
+	}
+}
+
+// logSummary - Log counts of updated and up to date branches and tags of the run, per repo and in total.
+func logSummary(results []*RepoResult) {
+	var total outcomeCounts
+	for _, r := range results {
+		r.tally()
+		total.add(r.Counts)
+		log.Infof("Summary of '%s': branches %d updated, %d up to date; tags %d updated, %d up to date, %d deleted",
+			r.Name, r.Counts.BranchesUpdated, r.Counts.BranchesUpToDate, r.Counts.TagsUpdated, r.Counts.TagsUpToDate,
+			r.Counts.TagsDeleted)
+	}
+	log.Infof("Summary of %d repos: branches %d updated, %d up to date; tags %d updated, %d up to date, %d deleted",
+		len(results), total.BranchesUpdated, total.BranchesUpToDate, total.TagsUpdated, total.TagsUpToDate,
+		total.TagsDeleted)
+}
+
+// writeReport - Write results of the run as JSON to the file at path.
+func writeReport(path string, results []*RepoResult) error {
+	for _, r := range results {
+		r.tally()
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %v", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write report '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// Formats of results printed besides logs, see --output-format.
+const (
+	outputText          = "text"
+	outputGithubActions = "github-actions"
+)
+
+// annotationEscaper - escapes data of GitHub Actions workflow commands, and annotationPropertyEscaper their properties.
+var (
+	annotationEscaper         = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	annotationPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+// writeAnnotations - Write GitHub Actions workflow commands annotating the run: `::error::` for failed repos and
+// `::warning::` for skipped repos and branches.
+func writeAnnotations(w io.Writer, results []*RepoResult) {
+	annotate := func(level string, title string, msg string) {
+		fmt.Fprintf(w, "::%s title=%s::%s\n", level, annotationPropertyEscaper.Replace(title), annotationEscaper.Replace(msg))
+	}
+
+	for _, r := range results {
+		title := fmt.Sprintf("repo %s", r.Name)
+		if r.Error != "" {
+			annotate("error", title, r.Error)
+		}
+		if r.Skipped != "" {
+			annotate("warning", title, fmt.Sprintf("repo '%s' skipped: %s", r.Name, r.Skipped))
+		}
+		for _, b := range r.Branches {
+			if b.Skipped != "" {
+				annotate("warning", title, fmt.Sprintf("branch %s of '%s' skipped: %s", b.Branch, r.Name, b.Skipped))
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+)
+
+// protocolTracer - writer of the protocol trace of the repo given by --trace-repo: git sessions with its remotes and
+// HTTP requests they make.
+type protocolTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// openProtocolTracer - Open the trace file, appending to it when it exists.
+func openProtocolTracer(path string) (*protocolTracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file '%s': %w", path, err)
+	}
+	return &protocolTracer{w: f}, nil
+}
+
+// printf - Write trace line, prefixed with time.
+func (t *protocolTracer) printf(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fmt.Fprintf(t.w, "%s %s\n", time.Now().Format("15:04:05.000000"), fmt.Sprintf(format, args...))
+}
+
+// tracerKey - context key of the protocol tracer of remote operations of the traced repo.
+type tracerKey struct{}
+
+// withTracer - Context of remote operations traced by t.
+func withTracer(ctx context.Context, t *protocolTracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// tracerFrom - Tracer of remote operations of the context, nil when they aren't traced.
+func tracerFrom(ctx context.Context) *protocolTracer {
+	t, _ := ctx.Value(tracerKey{}).(*protocolTracer)
+	return t
+}
+
+// installProtocolTracing - Wrap go-git transports of all protocols, so sessions of operations with a tracer in their
+// context get traced. Sessions of other repos pass through untouched.
+func installProtocolTracing() {
+	for protocol, next := range client.Protocols {
+		client.InstallProtocol(protocol, tracingTransport{next: next})
+	}
+}
+
+// redactedEndpoint - Endpoint as a string, without its password.
+func redactedEndpoint(ep *transport.Endpoint) string {
+	redacted := *ep
+	if redacted.Password != "" {
+		redacted.Password = "<redacted>"
+	}
+	return redacted.String()
+}
+
+// tracingTransport - transport.Transport wrapping sessions of the next one in traced ones.
+type tracingTransport struct {
+	next transport.Transport
+}
+
+func (t tracingTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	s, err := t.next.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedUploadPack{UploadPackSession: s, endpoint: redactedEndpoint(ep), auth: authName(auth)}, nil
+}
+
+func (t tracingTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	s, err := t.next.NewReceivePackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedReceivePack{ReceivePackSession: s, endpoint: redactedEndpoint(ep), auth: authName(auth)}, nil
+}
+
+// authName - Name of the auth method for traces, never its credentials.
+func authName(auth transport.AuthMethod) string {
+	if auth == nil {
+		return "none"
+	}
+	return auth.Name()
+}
+
+// traceAdvertisedRefs - Trace refs and capabilities the server advertised.
+func traceAdvertisedRefs(t *protocolTracer, service, endpoint, auth string, ar *packp.AdvRefs, err error, took time.Duration) {
+	t.printf("%s %s (auth %s): advertised refs in %s", service, endpoint, auth, took)
+	if err != nil {
+		t.printf("  error: %v", err)
+		return
+	}
+	t.printf("  capabilities: %s", ar.Capabilities.String())
+	if ar.Head != nil {
+		t.printf("  HEAD %s", ar.Head)
+	}
+	names := make([]string, 0, len(ar.References))
+	for name := range ar.References {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.printf("  %s %s", ar.References[name], name)
+	}
+	for _, s := range ar.Shallows {
+		t.printf("  shallow %s", s)
+	}
+}
+
+// tracedUploadPack - upload-pack (fetch) session traced when operations have a tracer in their context.
+type tracedUploadPack struct {
+	transport.UploadPackSession
+	endpoint string
+	auth     string
+}
+
+func (s *tracedUploadPack) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	start := time.Now()
+	ar, err := s.UploadPackSession.AdvertisedReferencesContext(ctx)
+	if t := tracerFrom(ctx); t != nil {
+		traceAdvertisedRefs(t, transport.UploadPackServiceName, s.endpoint, s.auth, ar, err, time.Since(start))
+	}
+	return ar, err
+}
+
+func (s *tracedUploadPack) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	t := tracerFrom(ctx)
+	if t == nil {
+		return s.UploadPackSession.UploadPack(ctx, req)
+	}
+
+	t.printf("%s %s: request, capabilities: %s", transport.UploadPackServiceName, s.endpoint, req.Capabilities.String())
+	for _, h := range req.Wants {
+		t.printf("  want %s", h)
+	}
+	for _, h := range req.Haves {
+		t.printf("  have %s", h)
+	}
+	for _, h := range req.Shallows {
+		t.printf("  shallow %s", h)
+	}
+	if req.Depth != nil && !req.Depth.IsZero() {
+		t.printf("  depth %v", req.Depth)
+	}
+
+	start := time.Now()
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	t.printf("%s %s: response in %s", transport.UploadPackServiceName, s.endpoint, time.Since(start))
+	if err != nil {
+		t.printf("  error: %v", err)
+		return resp, err
+	}
+	for _, h := range resp.ACKs {
+		t.printf("  ACK %s", h)
+	}
+	for _, h := range resp.Shallows {
+		t.printf("  shallow %s", h)
+	}
+	for _, h := range resp.Unshallows {
+		t.printf("  unshallow %s", h)
+	}
+	return resp, err
+}
+
+// tracedReceivePack - receive-pack (push) session traced when operations have a tracer in their context.
+type tracedReceivePack struct {
+	transport.ReceivePackSession
+	endpoint string
+	auth     string
+}
+
+func (s *tracedReceivePack) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	start := time.Now()
+	ar, err := s.ReceivePackSession.AdvertisedReferencesContext(ctx)
+	if t := tracerFrom(ctx); t != nil {
+		traceAdvertisedRefs(t, transport.ReceivePackServiceName, s.endpoint, s.auth, ar, err, time.Since(start))
+	}
+	return ar, err
+}
+
+func (s *tracedReceivePack) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	t := tracerFrom(ctx)
+	if t == nil {
+		return s.ReceivePackSession.ReceivePack(ctx, req)
+	}
+
+	t.printf("%s %s: request, capabilities: %s", transport.ReceivePackServiceName, s.endpoint, req.Capabilities.String())
+	for _, c := range req.Commands {
+		t.printf("  %s %s %s", c.Old, c.New, c.Name)
+	}
+	var packfile *countingReader
+	if req.Packfile != nil {
+		packfile = &countingReader{ReadCloser: req.Packfile}
+		req.Packfile = packfile
+	}
+
+	start := time.Now()
+	report, err := s.ReceivePackSession.ReceivePack(ctx, req)
+	t.printf("%s %s: response in %s", transport.ReceivePackServiceName, s.endpoint, time.Since(start))
+	if packfile != nil {
+		t.printf("  sent packfile of %d bytes", packfile.n)
+	}
+	if report != nil {
+		t.printf("  unpack %s", report.UnpackStatus)
+		for _, c := range report.CommandStatuses {
+			t.printf("  %s %s", c.ReferenceName, c.Status)
+		}
+	}
+	if err != nil {
+		t.printf("  error: %v", err)
+	}
+	return report, err
+}
+
+// countingReader - reader counting bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// traceTransport - http.RoundTripper tracing requests (made in context with a tracer) and their responses. Values of
+// request headers are left out, they may carry credentials.
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tracer := tracerFrom(req.Context())
+	if tracer == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	u := *req.URL
+	u.User = nil
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tracer.printf("> %s %s (headers %s)", req.Method, u.String(), strings.Join(names, ", "))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		tracer.printf("< %s %s failed after %s: %v", req.Method, u.String(), time.Since(start), err)
+		return resp, err
+	}
+	tracer.printf("< %s in %s, %s %s", resp.Status, time.Since(start), resp.Header.Get("Content-Type"), resp.Header.Get("Content-Length"))
+	return resp, err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// checkTreePaths - Check that checking out the commit only materializes entries within the worktree root: no path
+// components that are empty, `.`, `..` or `.git` (any case) or contain backslashes, and no symlinks pointing outside
+// of the root. Returns error listing the first offending entry.
+func checkTreePaths(repo *git.Repository, hash plumbing.Hash) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to read tree of commit %s: %w", hash, err)
+	}
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		for _, c := range strings.Split(f.Name, "/") {
+			if c == "" || c == "." || c == ".." || strings.EqualFold(c, ".git") || strings.Contains(c, `\`) {
+				return fmt.Errorf("unsafe path '%s' in commit %s", f.Name, hash)
+			}
+		}
+		if f.Mode != filemode.Symlink {
+			return nil
+		}
+
+		target, err := f.Contents()
+		if err != nil {
+			return fmt.Errorf("failed to read symlink '%s' in commit %s: %w", f.Name, hash, err)
+		}
+		resolved := path.Join(path.Dir(f.Name), target)
+		if path.IsAbs(target) || strings.Contains(target, `\`) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+			return fmt.Errorf("symlink '%s' in commit %s points outside of the worktree: %s", f.Name, hash, target)
+		}
+		return nil
+	})
+}
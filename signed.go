@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// What's done with branches whose tip isn't validly signed when requireSignedTip is set, see Repo.unsignedTip.
+const (
+	unsignedTipSkip = "skip"
+	unsignedTipFail = "fail"
+)
+
+// errUnsignedTip - tip commit of a branch has no signature, or one not made by a key of the signing keyring.
+var errUnsignedTip = errors.New("tip commit isn't signed by an approved key")
+
+// readKeyring - Read armored OpenPGP public keys of approved signers from the file.
+func readKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring '%s': %v", path, err)
+	}
+	defer f.Close()
+
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keyring '%s': %v", path, err)
+	}
+
+	return keyring, nil
+}
+
+// unsignedTip - What's done with branches whose tip isn't validly signed, unsignedTipSkip by default.
+func (r *Repo) unsignedTip() string {
+	if r.UnsignedTip == "" {
+		return unsignedTipSkip
+	}
+	return r.UnsignedTip
+}
+
+// verifySignedTip - Check that the commit is signed by a key of the repo's signing keyring, errUnsignedTip (with the
+// reason) when it isn't.
+func (r *Repo) verifySignedTip(commit *object.Commit) error {
+	if commit.PGPSignature == "" {
+		return fmt.Errorf("%w: commit %s has no signature", errUnsignedTip, commit.Hash)
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return fmt.Errorf("failed to encode commit %s: %w", commit.Hash, err)
+	}
+	signed, err := encoded.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to encode commit %s: %w", commit.Hash, err)
+	}
+	_, err = openpgp.CheckArmoredDetachedSignature(r.keyring, signed, strings.NewReader(commit.PGPSignature), nil)
+	if err != nil {
+		return fmt.Errorf("%w: signature of commit %s: %v", errUnsignedTip, commit.Hash, err)
+	}
+
+	return nil
+}
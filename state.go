@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// syncState - what's remembered about repos between sync cycles, and between runs when persisted to --state-file.
+type syncState struct {
+	mu    sync.Mutex
+	path  string
+	Repos map[string]*repoState `json:"repos"`
+}
+
+// repoState - remembered state of a repo, keyed by its name in syncState.
+type repoState struct {
+	// KnownTags are source tags as of the last successful sync of a tagTriggered repo.
+	KnownTags []string `json:"knownTags,omitempty"`
+	// Failures counts consecutive failed syncs, CooldownUntil is when a repo cooling down after them is synced again.
+	Failures      int        `json:"failures,omitempty"`
+	CooldownUntil *time.Time `json:"cooldownUntil,omitempty"`
+	// Diverged are (target) refs of protectDivergent repos whose tips diverged from source, with when it was first seen.
+	Diverged map[string]time.Time `json:"diverged,omitempty"`
+	// SourceHead is the source HEAD (tip of the default branch) as of the last successful sync, see --skip-unchanged-head.
+	SourceHead string `json:"sourceHead,omitempty"`
+}
+
+// loadState - Read state persisted at path, empty state when the file doesn't exist yet. With empty path the state is
+// only kept in memory.
+func loadState(path string) (*syncState, error) {
+	state := &syncState{path: path, Repos: map[string]*repoState{}}
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file '%s': %w", path, err)
+	}
+	if state.Repos == nil {
+		state.Repos = map[string]*repoState{}
+	}
+
+	return state, nil
+}
+
+// save - Persist the state to its file, replacing it at once so an interrupted write doesn't leave it truncated. Does
+// nothing for in-memory state.
+func (s *syncState) save() error {
+	if s == nil || s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file '%s': %w", s.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file '%s': %w", s.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file '%s': %w", s.path, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file '%s': %w", s.path, err)
+	}
+
+	return nil
+}
+
+// knownTags - Source tags of the repo as of its last successful sync, false when there's none remembered.
+func (s *syncState) knownTags(repo string) (map[string]bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs, ok := s.Repos[repo]
+	if !ok || rs.KnownTags == nil {
+		return nil, false
+	}
+	known := map[string]bool{}
+	for _, t := range rs.KnownTags {
+		known[t] = true
+	}
+	return known, true
+}
+
+// setKnownTags - Remember source tags of the repo after syncing it.
+func (s *syncState) setKnownTags(repo string, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	s.repo(repo).KnownTags = sorted
+}
+
+// repo - State of the repo, created when there's none yet. Caller holds the lock.
+func (s *syncState) repo(name string) *repoState {
+	if s.Repos[name] == nil {
+		s.Repos[name] = &repoState{}
+	}
+	return s.Repos[name]
+}
+
+// cooldownEnd - End of the cooldown of the repo after failures, zero when it has none; whether it's still ongoing.
+func (s *syncState) cooldownEnd(repo string, now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs, ok := s.Repos[repo]
+	if !ok || rs.CooldownUntil == nil {
+		return time.Time{}, false
+	}
+	return *rs.CooldownUntil, now.Before(*rs.CooldownUntil)
+}
+
+// recordSync - Count the outcome of syncing the repo, putting it into cooldown until now+cooldown once it failed
+// afterFailures times in a row, and again with each failure after that. Returns the consecutive failures and end of
+// the cooldown, zero when it isn't put into one.
+func (s *syncState) recordSync(repo string, failed bool, afterFailures int, cooldown time.Duration, now time.Time) (int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.repo(repo)
+	if !failed {
+		rs.Failures, rs.CooldownUntil = 0, nil
+		return 0, time.Time{}
+	}
+
+	rs.Failures++
+	if rs.Failures < afterFailures {
+		return rs.Failures, time.Time{}
+	}
+	until := now.Add(cooldown)
+	rs.CooldownUntil = &until
+	return rs.Failures, until
+}
+
+// divergedSince - When the target ref of the repo was first seen diverged from source, now when it's seen first.
+func (s *syncState) divergedSince(repo, ref string, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs := s.repo(repo)
+	if rs.Diverged == nil {
+		rs.Diverged = map[string]time.Time{}
+	}
+	if _, ok := rs.Diverged[ref]; !ok {
+		rs.Diverged[ref] = now
+	}
+	return rs.Diverged[ref]
+}
+
+// clearDiverged - Forget divergence of the target ref of the repo, once it no longer diverges or got overwritten.
+func (s *syncState) clearDiverged(repo, ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rs, ok := s.Repos[repo]; ok {
+		delete(rs.Diverged, ref)
+	}
+}
+
+// sourceHead - Source HEAD of the repo as of its last successful sync, false when there's none remembered.
+func (s *syncState) sourceHead(repo string) (plumbing.Hash, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs, ok := s.Repos[repo]
+	if !ok || rs.SourceHead == "" {
+		return plumbing.ZeroHash, false
+	}
+	return plumbing.NewHash(rs.SourceHead), true
+}
+
+// setSourceHead - Remember source HEAD of the repo after syncing it.
+func (s *syncState) setSourceHead(repo string, head plumbing.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.repo(repo).SourceHead = head.String()
+}
+
+// listSourceHead - List refs of the repo's source to resolve its HEAD, the tip of its default branch (primaryBranch
+// when set). Zero hash when the source has no such branch, e.g. when it's empty.
+func listSourceHead(rs *Repo, opts remoteOpts) (plumbing.Hash, error) {
+	byName, err := listRefsByName(rs, rs.SourceRemote, rs.sourceUrl(), opts)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	refs := make([]*plumbing.Reference, 0, len(byName))
+	for _, ref := range byName {
+		refs = append(refs, ref)
+	}
+	head, ok := byName[rs.sourceDefaultBranch(refs)]
+	if !ok || head.Type() != plumbing.HashReference {
+		return plumbing.ZeroHash, nil
+	}
+	return head.Hash(), nil
+}
+
+// newSourceTags - List source tags of the tagTriggered repo, returning them along with those not known from its last
+// successful sync, and whether any were remembered at all.
+func newSourceTags(rs *Repo, state *syncState, opts remoteOpts) (tags, added []string, remembered bool, err error) {
+	refs, err := listRefsByName(rs, rs.SourceRemote, rs.sourceUrl(), opts)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	tags = []string{}
+	for name := range refs {
+		if name.IsTag() {
+			tags = append(tags, name.Short())
+		}
+	}
+	sort.Strings(tags)
+
+	known, remembered := state.knownTags(rs.Name)
+	for _, t := range tags {
+		if !known[t] {
+			added = append(added, t)
+		}
+	}
+
+	return tags, added, remembered, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// subtreeRefPrefix - Local refs rewritten branches are kept under and pushed from, see subtreeRef.
+const subtreeRefPrefix = "refs/subtree/"
+
+// subtreeRef - Local ref the branch rewritten under subtreePrefix is pushed from.
+func subtreeRef(branch plumbing.ReferenceName) plumbing.ReferenceName {
+	return plumbing.ReferenceName(subtreeRefPrefix + strings.TrimPrefix(branch.String(), "refs/"))
+}
+
+// validSubtreePrefix - Whether the prefix is a relative slash separated path without empty, `.` or `..` components.
+func validSubtreePrefix(prefix string) bool {
+	for _, c := range strings.Split(prefix, "/") {
+		if c == "" || c == "." || c == ".." || strings.EqualFold(c, ".git") {
+			return false
+		}
+	}
+	return true
+}
+
+// subtreeRewriter - Rewrites history so trees of all commits are nested under prefix, like `git subtree` does. Commits
+// keep their authors, committers and messages (signatures are dropped), so rewriting is deterministic: the same source
+// history always yields the same rewritten one. Rewritten commits are remembered for the run.
+type subtreeRewriter struct {
+	repo      *git.Repository
+	prefix    []string
+	rewritten map[plumbing.Hash]plumbing.Hash
+}
+
+func newSubtreeRewriter(repo *git.Repository, prefix string) *subtreeRewriter {
+	return &subtreeRewriter{repo: repo, prefix: strings.Split(prefix, "/"), rewritten: map[plumbing.Hash]plumbing.Hash{}}
+}
+
+// rewrite - Rewrite history of the commit, returning hash of its rewritten counterpart. Parents are rewritten first,
+// walking with an explicit stack so deep histories don't exhaust the call stack.
+func (s *subtreeRewriter) rewrite(tip plumbing.Hash) (plumbing.Hash, error) {
+	stack := []plumbing.Hash{tip}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		if _, ok := s.rewritten[h]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		commit, err := s.repo.CommitObject(h)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to read commit %s: %w", h, err)
+		}
+		var pending []plumbing.Hash
+		parents := make([]plumbing.Hash, 0, len(commit.ParentHashes))
+		for _, p := range commit.ParentHashes {
+			if r, ok := s.rewritten[p]; ok {
+				parents = append(parents, r)
+			} else {
+				pending = append(pending, p)
+			}
+		}
+		if len(pending) > 0 {
+			stack = append(stack, pending...)
+			continue
+		}
+
+		tree, err := s.nestTree(commit.TreeHash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		rewritten := &object.Commit{
+			Author:       commit.Author,
+			Committer:    commit.Committer,
+			Message:      commit.Message,
+			TreeHash:     tree,
+			ParentHashes: parents,
+		}
+		if s.rewritten[h], err = s.store(rewritten); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to store rewritten commit %s: %w", h, err)
+		}
+		stack = stack[:len(stack)-1]
+	}
+
+	return s.rewritten[tip], nil
+}
+
+// nestTree - Store trees nesting the tree under the prefix, returning hash of the outermost one.
+func (s *subtreeRewriter) nestTree(tree plumbing.Hash) (plumbing.Hash, error) {
+	for i := len(s.prefix) - 1; i >= 0; i-- {
+		nested := &object.Tree{Entries: []object.TreeEntry{{Name: s.prefix[i], Mode: filemode.Dir, Hash: tree}}}
+		var err error
+		if tree, err = s.store(nested); err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to store tree nested under %s: %w", strings.Join(s.prefix, "/"), err)
+		}
+	}
+	return tree, nil
+}
+
+// store - Encode the object into the repo's storage.
+func (s *subtreeRewriter) store(o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := s.repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.repo.Storer.SetEncodedObject(obj)
+}
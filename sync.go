@@ -0,0 +1,1226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// runOptions - run-wide settings from the command line, applying to all repos.
+type runOptions struct {
+	onlyBranches    []string
+	retries         int
+	opTimeout       time.Duration
+	fetchAllRemotes bool
+	gc              bool
+	pruneTags       bool
+	gcEvery         int
+	parallel        int
+	groupedLogs     bool
+	// skipUnchanged skips branches whose target ref already points at the source tip, without checking them out.
+	skipUnchanged bool
+	// skipUnchangedHead skips repos whose source HEAD didn't change since their last successful sync, see state.
+	skipUnchangedHead bool
+	// quiet logs steady state messages (refs already up to date) at debug level, keeping daemon logs to actual changes.
+	quiet bool
+	// logStatus logs worktree status after syncing each branch.
+	logStatus bool
+	// allowDivergentOverwrite force pushes branches of protectDivergent repos regardless of divergence.
+	allowDivergentOverwrite bool
+	// allowLargePush pushes regardless of maxPushObjects and maxPushBytes of repos.
+	allowLargePush bool
+	// state is remembered between cycles (and runs, when persisted), see syncState.
+	state *syncState
+	// remoteHosts limits remote operations running at once per server across all repos, unlimited when nil.
+	remoteHosts *hostSlots
+	// traceRepo is the repo whose remote operations are traced by tracer, see protocolTracer.
+	traceRepo string
+	tracer    *protocolTracer
+	// ctx is canceled when the run gets interrupted, nil means the run can't be.
+	ctx context.Context
+}
+
+// context - Return context of the run, canceled when it gets interrupted.
+func (r *runOptions) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// repoContext - Context of remote operations of the repo, carrying the protocol tracer when it's the traced one.
+func (r *runOptions) repoContext(rs *Repo) context.Context {
+	if r.tracer != nil && rs.Name == r.traceRepo {
+		return withTracer(r.context(), r.tracer)
+	}
+	return r.context()
+}
+
+// logUpToDate - Log message about something already being up to date, at debug level when quiet.
+func (r *runOptions) logUpToDate(logger *log.Entry, format string, args ...interface{}) {
+	if r.quiet {
+		logger.Debugf(format, args...)
+		return
+	}
+	logger.Infof(format, args...)
+}
+
+// runCycle - Sync all repos once, up to run.parallel of them at a time, not starting new ones after the first failure.
+// Repos with gc enabled are garbage collected after syncing, every gcEvery-th cycle.
+func runCycle(repoSync *RepoSync, run *runOptions, cycle int) ([]*RepoResult, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		results  []*RepoResult
+		firstErr error
+	)
+	slots := make(chan struct{}, run.parallel)
+
+	for _, rs := range repoSync.Repos {
+		slots <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || run.context().Err() != nil {
+			break
+		}
+
+		if until, ongoing := run.state.cooldownEnd(rs.Name, time.Now()); repoSync.Cooldown > 0 && ongoing {
+			log.Infof("Skipping repo '%s': cooling down after failures until %s", rs.Name, until.Format(time.RFC3339))
+			mu.Lock()
+			results = append(results, &RepoResult{Name: rs.Name, Skipped: "cooldown"})
+			mu.Unlock()
+			<-slots
+			continue
+		} else if repoSync.Cooldown > 0 && !until.IsZero() {
+			log.Infof("Cooldown of repo '%s' is over, syncing it again", rs.Name)
+		}
+
+		wg.Add(1)
+		go func(rs *Repo) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			logger, flush := repoLogger(rs, run)
+			defer flush()
+
+			repoCtx, span := tracer.Start(run.context(), "repo", trace.WithAttributes(attribute.String("repo", rs.Name)))
+			defer span.End()
+			repoRun := *run
+			repoRun.ctx = repoCtx
+
+			result, err := syncRepo(repoSync, rs, &repoRun, logger)
+			if err != nil {
+				result.Error = err.Error()
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			} else if result.Skipped == "" {
+				if rs.Path != "" && (run.gc || rs.Gc) && cycle%run.gcEvery == 0 {
+					if err := gcRepo(rs, logger); err != nil {
+						logger.Warnf("gc of repo '%s' failed: %v", rs.Name, err)
+					}
+				}
+				if rs.PostSync != "" {
+					runPostSync(rs, result, logger)
+				}
+			}
+
+			if repoSync.Events != nil {
+				publishEvents(repoCtx, repoSync.Events, rs, result, logger)
+			}
+			if repoSync.Cooldown > 0 && !errors.Is(err, ErrInterrupted) {
+				failures, until := run.state.recordSync(rs.Name, err != nil, repoSync.cooldownAfterFailures(), time.Duration(repoSync.Cooldown), time.Now())
+				if !until.IsZero() {
+					logger.Warnf("Repo '%s' failed %d times in a row, cooling down until %s", rs.Name, failures, until.Format(time.RFC3339))
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, result)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(rs)
+	}
+	wg.Wait()
+
+	return results, firstErr
+}
+
+// syncRepo - Sync branches, tags and other configured refs of the repo from its source remote to the target one.
+// Returns result of syncing (also on failure, with what was synced until then) and SyncError on failure.
+func syncRepo(repoSync *RepoSync, rs *Repo, run *runOptions, logger *log.Entry) (*RepoResult, error) {
+	repoResult := &RepoResult{Name: rs.Name}
+
+	opts := remoteOpts{
+		ctx:     run.repoContext(rs),
+		retries: rs.retryCount(run.retries),
+		timeout: rs.timeout(run.opTimeout),
+		log:     logger,
+		hosts:   run.remoteHosts,
+	}
+
+	rs, err := availableSource(rs, opts)
+	if err != nil {
+		return repoResult, syncError(rs, err)
+	}
+
+	// With --skip-unchanged-head, repos whose source HEAD is where it was at their last successful sync are skipped
+	// after just listing source refs.
+	var sourceHeadHash plumbing.Hash
+	if run.skipUnchangedHead {
+		sourceHeadHash, err = listSourceHead(rs, opts)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to list HEAD of %s of '%s': %w", rs.SourceRemote.Name, rs.Name, err))
+		}
+		if last, ok := run.state.sourceHead(rs.Name); ok && !sourceHeadHash.IsZero() && last == sourceHeadHash {
+			run.logUpToDate(logger, "Skipping '%s': HEAD of %s unchanged at %s", rs.Name, rs.SourceRemote.Name, sourceHeadHash)
+			repoResult.Skipped = "head unchanged"
+			return repoResult, nil
+		}
+	}
+
+	// Tag triggered repos are only synced when the source got new tags, checked by cheaply listing its refs.
+	var sourceTagNames []string
+	if rs.TagTriggered {
+		var added []string
+		var remembered bool
+		sourceTagNames, added, remembered, err = newSourceTags(rs, run.state, opts)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to list tags of %s of '%s': %w", rs.SourceRemote.Name, rs.Name, err))
+		}
+		if remembered && len(added) == 0 {
+			run.logUpToDate(logger, "Skipping '%s': no new tags on %s", rs.Name, rs.SourceRemote.Name)
+			repoResult.Skipped = "no new tags"
+			return repoResult, nil
+		}
+		if remembered {
+			logger.Infof("New tags on %s of '%s': %s", rs.SourceRemote.Name, rs.Name, strings.Join(added, ", "))
+		}
+	}
+
+	sourceAuth, err := rs.SourceRemote.auth()
+	if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to set up auth for %s of '%s': %w", rs.SourceRemote.Name, rs.Path, err))
+	}
+	targetAuth, err := rs.TargetRemote.auth()
+	if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to set up auth for %s of '%s': %w", rs.TargetRemote.Name, rs.Path, err))
+	}
+
+	if rs.Path == "" {
+		cloneOpts := opts
+		cloneOpts.retries = rs.fetchRetries(run.retries)
+		dir, err := cloneToTemp(rs, sourceAuth, cloneOpts)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to clone %s: %w", rs.sourceUrl(), err))
+		}
+		defer func() {
+			logger.Infof("Removing temporary clone %s of '%s'", dir, rs.Name)
+			if err := os.RemoveAll(dir); err != nil {
+				logger.Warnf("failed to remove temporary clone %s: %v", dir, err)
+			}
+		}()
+
+		tmp := *rs
+		tmp.Path = dir
+		rs = &tmp
+	}
+
+	logger.Infof("Opening %s...", rs.Path)
+	repo, err := openRepo(rs)
+	if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to open repo from %s: %w", rs.Path, err))
+	}
+
+	// Shallow repos miss history the target needs and go-git can't deepen them, pushes would fail with missing objects.
+	shallow, err := repo.Storer.Shallow()
+	if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to check whether repo %s is shallow: %w", rs.Path, err))
+	}
+	if len(shallow) > 0 {
+		logger.Warnf("Skipping %s: repository is shallow and can't be pushed, run 'git fetch --unshallow' in it first", rs.Path)
+		repoResult.Skipped = "shallow repository"
+		return repoResult, nil
+	}
+
+	// On interrupt, check out the branch that was checked out before instead of leaving the one being synced.
+	if head, err := repo.Head(); err == nil && head.Name().IsBranch() {
+		defer func() {
+			if opts.ctx.Err() == nil {
+				return
+			}
+			w, err := repo.Worktree()
+			if err == nil {
+				err = w.Checkout(&git.CheckoutOptions{
+					Branch: head.Name(),
+					Force:  rs.checkoutForce(),
+					Keep:   rs.CheckoutKeep,
+
+					SparseCheckoutDirectories: rs.SparseCheckout,
+				})
+			}
+			if err != nil {
+				logger.Warnf("Interrupted, failed to restore branch %s in %s: %v", head.Name().Short(), rs.Path, err)
+				return
+			}
+			logger.Warnf("Interrupted, restored branch %s in %s", head.Name().Short(), rs.Path)
+		}()
+	}
+
+	if err := removePartialPacks(repo, logger); err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to remove partial packfiles of %s: %w", rs.Path, err))
+	}
+
+	// Add source remote if doesn't exist and there's where to fetch from.
+	if sourceRemote, err := repo.Remote(rs.SourceRemote.Name); err == nil {
+		if url := rs.sourceUrl(); url != "" && sourceRemote.Config().URLs[0] != url {
+			logger.Warnf("Source remote %s of '%s' fetches from %s, not configured %s", rs.SourceRemote.Name, rs.Path, sourceRemote.Config().URLs[0], url)
+		}
+	} else if rs.sourceUrl() != "" {
+		logger.Infof("Source remote %s missing for '%s' ... adding %s", rs.SourceRemote.Name, rs.Path, rs.sourceUrl())
+		_, err = repo.CreateRemote(&config.RemoteConfig{
+			Name: rs.SourceRemote.Name,
+			URLs: []string{rs.sourceUrl()},
+		})
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to add source remote %s for '%s': %w", rs.SourceRemote.Name, rs.Path, err))
+		}
+	}
+
+	remotes, err := repo.Remotes()
+	if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to get remotes for %s: %w", rs.Path, err))
+	}
+
+	// Add target remote if doesn't exist.
+	targetRemote, err := repo.Remote(rs.TargetRemote.Name)
+	if err != nil && !rs.targetRemoteCreated() {
+		return repoResult, syncError(rs, fmt.Errorf("target remote %s missing for '%s' and createTargetRemote is disabled: %w", rs.TargetRemote.Name, rs.Path, err))
+	}
+	if err != nil {
+		logger.Infof("Target remote %s missing for '%s' ... adding %s", rs.TargetRemote.Name, rs.Path, rs.TargetRemote.Url)
+		targetRemote, err = repo.CreateRemote(&config.RemoteConfig{
+			Name: rs.TargetRemote.Name,
+			URLs: []string{rs.TargetRemote.Url},
+		})
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to add target remote %s for '%s': %w", rs.TargetRemote.Name, rs.Path, err))
+		}
+	}
+
+	// Operations with the target and source remotes are limited by slots of their servers.
+	targetOpts := opts.towards(targetRemote.Config().URLs[0])
+	targetOpts.retries = rs.pushRetries(run.retries)
+	sourceOpts := opts
+	sourceOpts.retries = rs.fetchRetries(run.retries)
+	targetRefs, err := listRemoteRefs(targetRemote, targetAuth, opts)
+	if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to list target remote %s for '%s': %w", rs.TargetRemote.Name, rs.Path, err))
+	}
+	targetEmpty := len(targetRefs) == 0
+	targetHashes := map[plumbing.ReferenceName]plumbing.Hash{}
+	var targetHaves []plumbing.Hash
+	for _, r := range targetRefs {
+		targetHashes[r.Name()] = r.Hash()
+		targetHaves = append(targetHaves, r.Hash())
+	}
+	// Objects the target has (as far as known locally), for estimating sizes of pushes.
+	pushes := newPushEstimator(repo, targetHaves)
+
+	var branchesToSync []*plumbing.Reference
+	var extraFetchRefSpecs, extraPushRefSpecs []config.RefSpec
+	var sourceHead plumbing.ReferenceName
+	sourceTags := map[plumbing.ReferenceName]bool{}
+
+	// Fetch source, plus target's branches for counting pushed commits; other remotes only when asked to.
+	for _, remote := range remotes {
+		fetchOpts := opts.towards(remote.Config().URLs[0])
+		fetchOpts.retries = rs.fetchRetries(run.retries)
+		if remote.Config().Name == rs.SourceRemote.Name {
+			sourceOpts = fetchOpts
+		}
+		tagMode := git.AllTags
+		if !rs.tagsEnabled() {
+			tagMode = git.NoTags
+		}
+		var auth transport.AuthMethod
+		switch remote.Config().Name {
+		case rs.SourceRemote.Name:
+			auth = sourceAuth
+		case rs.TargetRemote.Name:
+			if targetEmpty && !run.fetchAllRemotes {
+				logger.Debugf("Skipping fetch of empty target remote '%s' in '%s' repo", remote.Config().Name, rs.Path)
+				continue
+			}
+			auth = targetAuth
+		default:
+			if !run.fetchAllRemotes {
+				logger.Debugf("Skipping fetch of unrelated remote '%s' in '%s' repo", remote.Config().Name, rs.Path)
+				continue
+			}
+		}
+		logger.Infof("Found remote '%s' in '%s' repo... fetching", remote.Config().Name, rs.Path)
+		var refSpecs []config.RefSpec
+		if remote.Config().Name == rs.SourceRemote.Name {
+			refSpecs = rs.sourceFetchRefSpecs()
+			branch, err := singleSourceBranch(remote, rs, run, auth, opts)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to list refs of %s in '%s' repo: %w", remote.Config().Name, rs.Path, err))
+			}
+			if branch != "" {
+				logger.Infof("Fetching only branch %s from '%s' in '%s' repo", branch, remote.Config().Name, rs.Path)
+				refSpecs = []config.RefSpec{rs.branchFetchRefSpec(branch)}
+			}
+		}
+		if rs.IncrementalFetch && remote.Config().Name == rs.SourceRemote.Name {
+			err = fetchIncrementally(remote, auth, tagMode, refSpecs, fetchOpts)
+		} else {
+			err = fetchOpts.run(fmt.Sprintf("fetch %s", remote.Config().Name), func(ctx context.Context) error {
+				return fetchRemote(ctx, remote, &git.FetchOptions{
+					RemoteName: remote.String(),
+					RefSpecs:   refSpecs,
+					Tags:       tagMode,
+					Auth:       auth,
+				})
+			})
+		}
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return repoResult, syncError(rs, fmt.Errorf("failed to fetch %s in '%s' repo: %w", remote.Config().Name, rs.Path, err))
+		}
+
+		if remote.Config().Name == rs.SourceRemote.Name {
+			if rs.CommitGraph {
+				writeCommitGraph(rs.Path, logger)
+			}
+			remoteRefs, err := listRemoteRefs(remote, sourceAuth, opts)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to get remote objects for remote '%s' in repo '%s': %w", remote.Config().Name, rs.Path, err))
+			}
+
+			sourceHead = rs.sourceDefaultBranch(remoteRefs)
+			var headBranch plumbing.ReferenceName
+			if rs.DefaultBranchOnly {
+				headBranch = sourceHead
+				if headBranch == "" {
+					return repoResult, syncError(rs, fmt.Errorf("failed to determine default branch of remote '%s' in repo '%s'", remote.Config().Name, rs.Path))
+				}
+				logger.Infof("Syncing only default branch '%s' of remote '%s' in repo '%s'.", headBranch, remote.Config().Name, rs.Path)
+			}
+
+			for _, r := range remoteRefs {
+				if r.Name().IsTag() {
+					sourceTags[r.Name()] = true
+				}
+				name, ok := rs.sourceBranch(r.Name())
+				if rs.branchesEnabled() && ok && (headBranch == "" || name == headBranch) &&
+					rs.branchSelected(name.Short(), run.onlyBranches) {
+					logger.Infof("Found remote branch '%s' for remote '%s' in repo '%s'.", r.Name(), remote.Config().Name, rs.Path)
+					branchesToSync = append(branchesToSync, plumbing.NewHashReference(name, r.Hash()))
+				}
+			}
+
+			if rs.MergedInto != "" && len(branchesToSync) > 0 {
+				var base *plumbing.Reference
+				for _, r := range remoteRefs {
+					if name, ok := rs.sourceBranch(r.Name()); ok && name == plumbing.NewBranchReferenceName(rs.MergedInto) {
+						base = r
+					}
+				}
+				if base == nil {
+					return repoResult, syncError(rs, fmt.Errorf("branch %s to filter merged branches by missing on remote '%s' in repo '%s'", rs.MergedInto, remote.Config().Name, rs.Path))
+				}
+
+				// Walking the commit graph fails with missing history, sync everything rather than nothing then.
+				merged, unmerged, err := filterMerged(repo, branchesToSync, base.Hash())
+				if err != nil {
+					logger.Warnf("failed to check which branches are merged into %s in %s, syncing all: %v", rs.MergedInto, rs.Path, err)
+				} else {
+					for _, b := range unmerged {
+						logger.Infof("Skipping branch %s of %s, not merged into %s", b.Name().Short(), rs.Path, rs.MergedInto)
+					}
+					branchesToSync = merged
+				}
+			}
+
+			if len(rs.BranchAuthorDomain) > 0 {
+				var authored []*plumbing.Reference
+				for _, b := range branchesToSync {
+					tip, err := repo.CommitObject(b.Hash())
+					if err != nil {
+						logger.Warnf("failed to read tip commit of %s in %s, syncing it regardless of author: %v", b.Name().Short(), rs.Path, err)
+					} else if !authoredInDomain(tip, rs.BranchAuthorDomain) {
+						logger.Infof("Skipping branch %s of %s, tip authored by %s", b.Name().Short(), rs.Path, tip.Author.Email)
+						continue
+					}
+					authored = append(authored, b)
+				}
+				branchesToSync = authored
+			}
+
+			// Mirror only extra refs the source has, fetching a refspec matching nothing fails.
+			for _, m := range rs.extraRefs() {
+				for _, r := range remoteRefs {
+					if m.fetchRefSpec().Match(r.Name()) {
+						logger.Infof("Found refs '%s' for remote '%s' in repo '%s'.", m.Source, remote.Config().Name, rs.Path)
+						extraFetchRefSpecs = append(extraFetchRefSpecs, m.fetchRefSpec())
+						extraPushRefSpecs = append(extraPushRefSpecs, m.pushRefSpec())
+						break
+					}
+				}
+			}
+
+			if len(extraFetchRefSpecs) > 0 {
+				logger.Infof("Fetching refs %v from '%s' in '%s' repo", extraFetchRefSpecs, remote.Config().Name, rs.Path)
+				err = sourceOpts.run("fetch refs", func(ctx context.Context) error {
+					return remote.FetchContext(ctx, &git.FetchOptions{
+						RefSpecs: extraFetchRefSpecs,
+						Force:    true,
+						Auth:     sourceAuth,
+					})
+				})
+				if err != nil && err != git.NoErrAlreadyUpToDate {
+					return repoResult, syncError(rs, fmt.Errorf("failed to fetch refs from %s in '%s' repo: %w", remote.Config().Name, rs.Path, err))
+				}
+			}
+		}
+	}
+
+	if rs.Fsck {
+		logger.Infof("Verifying objects of %s", rs.Path)
+		tips := make([]plumbing.Hash, 0, len(branchesToSync))
+		for _, b := range branchesToSync {
+			tips = append(tips, b.Hash())
+		}
+		verified, err := fsckRepo(repo, tips)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("integrity check of %s failed, not pushing from it: %w", rs.Path, err))
+		}
+		logger.Infof("Verified %d objects of %s", verified, rs.Path)
+	}
+
+	// Branches differing only in case can't coexist in a worktree on case-insensitive filesystems.
+	collidingBranches := map[plumbing.ReferenceName]bool{}
+	if groups := caseCollisions(branchesToSync); len(groups) > 0 {
+		var described []string
+		for _, g := range groups {
+			var names []string
+			for _, b := range g {
+				names = append(names, b.Short())
+				collidingBranches[b] = true
+			}
+			described = append(described, strings.Join(names, ", "))
+		}
+		if rs.caseCollisions() == collisionsError {
+			return repoResult, syncError(rs, fmt.Errorf("branches of %s differ only in case, checking them out fails on case-insensitive filesystems: %s (set caseCollisions: push to push them without checkout)",
+				rs.Path, strings.Join(described, "; ")))
+		}
+		logger.Warnf("Branches of %s differ only in case, pushing them without checkout: %s", rs.Path, strings.Join(described, "; "))
+	}
+
+	// Push the default branch first into an empty target - hosting services make the first pushed branch the default.
+	if targetEmpty && rs.DefaultBranch != "" {
+		logger.Infof("Target remote %s of '%s' is empty, pushing default branch %s first", rs.TargetRemote.Name, rs.Path, rs.DefaultBranch)
+		for i, b := range branchesToSync {
+			if repoSync.mapBranch(b.Name().Short()) == rs.DefaultBranch {
+				branchesToSync = append(append([]*plumbing.Reference{b}, branchesToSync[:i]...), branchesToSync[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// Every synced branch gets its own span, parent of its remote operations.
+	var branchSpan trace.Span
+	endBranchSpan := func() {
+		if branchSpan != nil {
+			branchSpan.End()
+			branchSpan = nil
+		}
+	}
+	defer endBranchSpan()
+
+	// Refs are listed in no particular order, sorting keeps logs and push order the same across runs.
+	sort.Slice(branchesToSync, func(i, j int) bool { return branchesToSync[i].Name() < branchesToSync[j].Name() })
+	logger.Infof("Branches to sync: %v", branchesToSync)
+	var subtree *subtreeRewriter
+	if rs.SubtreePrefix != "" {
+		subtree = newSubtreeRewriter(repo, rs.SubtreePrefix)
+	}
+	lfsWarned := false
+	for _, remoteBranch := range branchesToSync {
+		endBranchSpan()
+		var branchCtx context.Context
+		branchCtx, branchSpan = tracer.Start(run.repoContext(rs), "branch", trace.WithAttributes(
+			attribute.String("repo", rs.Name),
+			attribute.String("branch", remoteBranch.Name().Short()),
+		))
+		opts, sourceOpts, targetOpts := opts, sourceOpts, targetOpts
+		opts.ctx, sourceOpts.ctx, targetOpts.ctx = branchCtx, branchCtx, branchCtx
+
+		if rs.SkipCommitMarker != "" {
+			tip, err := repo.CommitObject(remoteBranch.Hash())
+			if err != nil {
+				logger.Warnf("failed to read tip commit of %s in %s, syncing it regardless of skip marker: %v", remoteBranch.Name().Short(), rs.Path, err)
+			} else if strings.Contains(tip.Message, rs.SkipCommitMarker) {
+				logger.Infof("Skipping branch %s of %s: tip commit %s is marked %s", remoteBranch.Name().Short(), rs.Path, tip.Hash, rs.SkipCommitMarker)
+				repoResult.Branches = append(repoResult.Branches, &BranchResult{
+					Branch:  remoteBranch.Name().Short(),
+					Target:  repoSync.mapBranch(remoteBranch.Name().Short()),
+					Hash:    tip.Hash.String(),
+					Skipped: "commit marker",
+				})
+				branchSpan.SetAttributes(attribute.String("outcome", "skipped"))
+				continue
+			}
+		}
+
+		if rs.RequireSignedTip {
+			tip, err := repo.CommitObject(remoteBranch.Hash())
+			if err == nil {
+				err = rs.verifySignedTip(tip)
+			}
+			if err != nil && (!errors.Is(err, errUnsignedTip) || rs.unsignedTip() == unsignedTipFail) {
+				return repoResult, syncError(rs, fmt.Errorf("branch %s of %s: %w", remoteBranch.Name().Short(), rs.Path, err))
+			}
+			if err != nil {
+				logger.Warnf("Skipping branch %s of %s: %v", remoteBranch.Name().Short(), rs.Path, err)
+				repoResult.Branches = append(repoResult.Branches, &BranchResult{
+					Branch:  remoteBranch.Name().Short(),
+					Target:  repoSync.mapBranch(remoteBranch.Name().Short()),
+					Hash:    remoteBranch.Hash().String(),
+					Skipped: "unsigned",
+				})
+				branchSpan.SetAttributes(attribute.String("outcome", "skipped"))
+				continue
+			}
+		}
+
+		if run.skipUnchanged {
+			mapped := repoSync.mapBranch(remoteBranch.Name().Short())
+			if h, ok := targetHashes[rs.targetRef(plumbing.NewBranchReferenceName(mapped))]; ok && h == remoteBranch.Hash() {
+				run.logUpToDate(logger, "Branch %s of %s unchanged on target, skipping it", remoteBranch.Name().Short(), rs.Path)
+				repoResult.Branches = append(repoResult.Branches, &BranchResult{
+					Branch:  remoteBranch.Name().Short(),
+					Target:  mapped,
+					Hash:    h.String(),
+					Outcome: outcomeUpToDate,
+				})
+				branchSpan.SetAttributes(attribute.String("outcome", outcomeUpToDate))
+				continue
+			}
+		}
+
+		if !rs.SkipLFS && !lfsWarned {
+			if lfs, err := usesLFS(repo, remoteBranch.Hash()); err == nil && lfs {
+				logger.Warnf("Branch %s of %s uses Git LFS: only pointer files are mirrored, LFS objects aren't fetched nor pushed; set skipLFS to acknowledge", remoteBranch.Name().Short(), rs.Path)
+				lfsWarned = true
+			}
+		}
+
+		// Branches colliding by case are pushed straight from their remote-tracking refs, without checking them out.
+		pushRef := plumbing.NewRemoteReferenceName(rs.SourceRemote.Name, remoteBranch.Name().Short())
+		var w *git.Worktree
+		if collidingBranches[remoteBranch.Name()] {
+			logger.Infof("Pushing branch %s of %s from %s without checking it out", remoteBranch.Name().Short(), rs.Path, pushRef)
+		} else {
+			if rs.SafePaths {
+				if err := checkTreePaths(repo, remoteBranch.Hash()); err != nil {
+					return repoResult, syncError(rs, fmt.Errorf("refusing to check out %s in %s: %w", remoteBranch.Name().Short(), rs.Path, err))
+				}
+			}
+			w, err = repo.Worktree()
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to get working tree for repository %s: %w", rs.Path, err))
+			}
+			if rs.ignoredFiles() != ignoredFilesOverwrite && rs.resetMode() != git.SoftReset {
+				clobbered, err := clobberedIgnoredFiles(repo, w, remoteBranch.Hash())
+				if err != nil {
+					return repoResult, syncError(rs, fmt.Errorf("failed to check ignored files of %s: %w", rs.Path, err))
+				}
+				if len(clobbered) > 0 {
+					if rs.ignoredFiles() == ignoredFilesAbort {
+						return repoResult, syncError(rs, fmt.Errorf("refusing to check out %s in %s, it would remove or overwrite ignored files: %s",
+							remoteBranch.Name().Short(), rs.Path, strings.Join(clobbered, ", ")))
+					}
+					logger.Warnf("Checking out %s in %s removes or overwrites ignored files: %s", remoteBranch.Name().Short(), rs.Path, strings.Join(clobbered, ", "))
+				}
+			}
+
+			localBranch, err := repoGetLocalBranchForRemote(repo, remoteBranch)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to determine whether repo %v already had local copy of branch %s in %s", repo, remoteBranch, rs.Path))
+			}
+
+			if localBranch == nil {
+				logger.Infof("Checking out branch %s in %s", remoteBranch.Name().Short(), rs.Path)
+				err = w.Checkout(&git.CheckoutOptions{
+					Hash:   remoteBranch.Hash(),
+					Branch: remoteBranch.Name(),
+					Create: true,
+					Force:  rs.checkoutForce(),
+					Keep:   rs.CheckoutKeep,
+
+					SparseCheckoutDirectories: rs.SparseCheckout,
+				})
+				if err != nil {
+					return repoResult, syncError(rs, fmt.Errorf("failed to checkout %s in %s: %w", remoteBranch.Name().Short(), rs.Path, err))
+				}
+				localBranch, err = repo.Head()
+				if err != nil {
+					return repoResult, syncError(rs, fmt.Errorf("failed to get branch HEAD after checkout: %w", err))
+				}
+				if localBranch.Hash() != remoteBranch.Hash() || localBranch.Name() != remoteBranch.Name() {
+					return repoResult, syncError(rs, fmt.Errorf("failed to check out branch correctly: %s vs %s; %s vs %s",
+						localBranch.Hash(), remoteBranch.Hash(), localBranch.Name(), remoteBranch.Name()))
+				}
+			} else {
+				logger.Infof("Switching to branch %s in %s", localBranch.Name().Short(), rs.Path)
+				err = w.Checkout(&git.CheckoutOptions{
+					Branch: localBranch.Name(),
+					Create: false,
+					Force:  rs.checkoutForce(),
+					Keep:   rs.CheckoutKeep,
+
+					SparseCheckoutDirectories: rs.SparseCheckout,
+				})
+				if err != nil {
+					return repoResult, syncError(rs, fmt.Errorf("failed to switch to %s in %s: %w", localBranch.Name().Short(), rs.Path, err))
+				}
+			}
+
+			tip := remoteBranch.Hash()
+			if rs.updateStrategy() == updatePull {
+				logger.Infof("Updating branch %s by pulling from '%s' of %s", remoteBranch.Name().Short(), rs.SourceRemote.Name, rs.Path)
+				err = sourceOpts.run(fmt.Sprintf("pull %s", remoteBranch.Name().Short()), func(ctx context.Context) error {
+					return w.PullContext(ctx, &git.PullOptions{
+						RemoteName:    rs.SourceRemote.Name,
+						ReferenceName: remoteBranch.Name(),
+						SingleBranch:  true,
+						Force:         true,
+						Auth:          sourceAuth,
+					})
+				})
+				if err != nil && err != git.NoErrAlreadyUpToDate {
+					return repoResult, syncError(rs, fmt.Errorf("failed to pull %s in %s: %w", remoteBranch.Name().Short(), rs.Path, err))
+				}
+
+				head, err := repo.Head()
+				if err != nil {
+					return repoResult, syncError(rs, fmt.Errorf("failed to get branch HEAD after pull: %w", err))
+				}
+				tip = head.Hash()
+			} else {
+				logger.Infof("Updating branch %s by resetting to tip of '%s' of %s", remoteBranch.Name().Short(), rs.SourceRemote.Name, rs.Path)
+			}
+
+			logger.Infof("Reseting branch %s to %s", localBranch.Name().Short(), tip)
+			err = w.ResetSparsely(&git.ResetOptions{
+				Commit: tip,
+				Mode:   rs.resetMode(),
+			}, rs.SparseCheckout)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to reset branch %s in %s: %w", remoteBranch.Name().Short(), rs.Path, err))
+			}
+			pushRef = localBranch.Name()
+		}
+
+		if subtree != nil {
+			tip, err := repo.Reference(pushRef, true)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to resolve branch %s in %s: %w", pushRef.Short(), rs.Path, err))
+			}
+			logger.Infof("Rewriting history of %s under %s", remoteBranch.Name().Short(), rs.SubtreePrefix)
+			rewritten, err := subtree.rewrite(tip.Hash())
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to rewrite %s under %s: %w", remoteBranch.Name().Short(), rs.SubtreePrefix, err))
+			}
+			pushRef = subtreeRef(remoteBranch.Name())
+			if err := repo.Storer.SetReference(plumbing.NewHashReference(pushRef, rewritten)); err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to store rewritten %s: %w", remoteBranch.Name().Short(), err))
+			}
+		}
+
+		mappedBranch := repoSync.mapBranch(remoteBranch.Name().Short())
+		targetBranch := rs.targetRef(plumbing.NewBranchReferenceName(mappedBranch))
+		refSpec, err := rs.branchPushSpec(pushRef, mappedBranch)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to make refspec pushing %s: %w", remoteBranch.Name().Short(), err))
+		}
+		if rs.PushRefSpecTemplate != "" {
+			targetBranch = refSpec.Dst(pushRef)
+		}
+		refSpecStr := refSpec.String()
+		if !run.allowLargePush {
+			if err := rs.checkPushSize(pushes, pushRef); err != nil {
+				return repoResult, syncError(rs, err)
+			}
+		}
+		if previous, ok := targetHashes[targetBranch]; ok && rs.ProtectDivergent {
+			tip, err := repo.Reference(pushRef, true)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to resolve branch %s in %s: %w", pushRef.Short(), rs.Path, err))
+			}
+			diverged, err := targetDiverged(repo, previous, tip.Hash())
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to check divergence of %s on target: %w", mappedBranch, err))
+			}
+			since := time.Now()
+			if diverged {
+				since = run.state.divergedSince(rs.Name, targetBranch.String(), since)
+			}
+			switch {
+			case !diverged:
+				run.state.clearDiverged(rs.Name, targetBranch.String())
+			case run.allowDivergentOverwrite:
+				logger.Warnf("Branch %s on target diverged from source (target tip %s), overwriting it as asked to", mappedBranch, previous)
+				run.state.clearDiverged(rs.Name, targetBranch.String())
+			case rs.DivergentGrace > 0 && time.Since(since) >= time.Duration(rs.DivergentGrace):
+				logger.Warnf("Branch %s on target diverged from source since %s (target tip %s), overwriting it after %s grace", mappedBranch, since.Format(time.RFC3339), previous, rs.DivergentGrace)
+				run.state.clearDiverged(rs.Name, targetBranch.String())
+			default:
+				logger.Warnf("Skipping branch %s of %s: target tip %s isn't an ancestor of source tip %s (pushed to the target directly?), not overwriting it without --allow-divergent-overwrite",
+					remoteBranch.Name().Short(), rs.Path, previous, tip.Hash())
+				repoResult.Branches = append(repoResult.Branches, &BranchResult{
+					Branch:  remoteBranch.Name().Short(),
+					Target:  mappedBranch,
+					Hash:    tip.Hash().String(),
+					OldHash: previous.String(),
+					Skipped: "diverged",
+				})
+				branchSpan.SetAttributes(attribute.String("outcome", "skipped"))
+				continue
+			}
+		}
+		logger.Infof("Pushing %s", refSpec)
+		err = targetOpts.run(fmt.Sprintf("push %s", refSpec), func(ctx context.Context) error {
+			return repo.PushContext(ctx, &git.PushOptions{
+				RemoteName: rs.TargetRemote.Name,
+				Force:      refSpec.IsForceUpdate(),
+				RefSpecs:   []config.RefSpec{refSpec},
+				Atomic:     true,
+				Auth:       targetAuth,
+			})
+		})
+		outcome := outcomeUpdated
+		if err != nil {
+			if err == git.NoErrAlreadyUpToDate {
+				run.logUpToDate(logger, "remote up to date - %s", refSpecStr)
+				outcome = outcomeUpToDate
+			} else if rs.GerritReview && strings.Contains(err.Error(), "no new changes") {
+				// Gerrit rejects pushes for review of commits it already has changes (or the branch) for.
+				run.logUpToDate(logger, "no new changes for review - %s", refSpecStr)
+				outcome = outcomeUpToDate
+			} else {
+				err = signedPushHint(err, targetRemote, targetAuth, opts)
+				return repoResult, syncError(rs, fmt.Errorf("failed to push %s: %w", refSpecStr, err))
+			}
+		}
+
+		branchSpan.SetAttributes(attribute.String("outcome", outcome))
+
+		pushed, err := repo.Reference(pushRef, true)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to resolve pushed branch %s in %s: %w", pushRef.Short(), rs.Path, err))
+		}
+		if err := pushes.pushed(pushed.Hash()); err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to walk pushed branch %s in %s: %w", pushRef.Short(), rs.Path, err))
+		}
+		previous, existed := targetHashes[targetBranch]
+		branchResult := &BranchResult{
+			Branch:    remoteBranch.Name().Short(),
+			Target:    mappedBranch,
+			Hash:      pushed.Hash().String(),
+			NewBranch: !existed,
+			Outcome:   outcome,
+		}
+		if existed {
+			branchResult.OldHash = previous.String()
+		}
+		repoResult.Branches = append(repoResult.Branches, branchResult)
+		branchResult.Commits, err = countCommits(repo, pushed.Hash(), previous, maxCountedCommits)
+		if err != nil {
+			logger.Warnf("failed to count commits pushed to %s: %v", mappedBranch, err)
+		} else if existed && outcome == outcomeUpdated {
+			logger.Infof("pushed %d new commits to %s", branchResult.Commits, mappedBranch)
+		} else if !existed {
+			logger.Infof("new branch %s with %d commits", mappedBranch, branchResult.Commits)
+		}
+
+		// Status of large worktrees is slow to compute, it's only logged when asked for.
+		if w == nil || !run.logStatus {
+			continue
+		}
+		status, err := w.Status()
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to get repo status: %w", err))
+		} else {
+			logger.Infof("Repository status: %v", status)
+		}
+	}
+
+	if targetEmpty && rs.DefaultBranch != "" {
+		ok, err := setRemoteHead(opts.ctx, targetRemote, rs.DefaultBranch, rs.Provider)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to set HEAD of target remote %s for '%s' to %s: %w", rs.TargetRemote.Name, rs.Path, rs.DefaultBranch, err))
+		}
+		if ok {
+			logger.Infof("Set HEAD of target remote %s for '%s' to %s", rs.TargetRemote.Name, rs.Path, rs.DefaultBranch)
+		} else {
+			logger.Infof("Target remote %s for '%s' isn't local, relying on it picking first pushed branch %s as default",
+				rs.TargetRemote.Name, rs.Path, rs.DefaultBranch)
+		}
+	}
+
+	if rs.SyncHead {
+		if sourceHead == "" {
+			return repoResult, syncError(rs, fmt.Errorf("failed to determine HEAD of source remote %s in '%s'", rs.SourceRemote.Name, rs.Path))
+		}
+		targetHead := repoSync.mapBranch(sourceHead.Short())
+		ok, err := setRemoteHead(opts.ctx, targetRemote, targetHead, rs.Provider)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to set HEAD of target remote %s for '%s' to %s: %w", rs.TargetRemote.Name, rs.Path, targetHead, err))
+		}
+		if ok {
+			logger.Infof("Set HEAD of target remote %s for '%s' to %s, matching source", rs.TargetRemote.Name, rs.Path, targetHead)
+		} else {
+			logger.Warnf("Can't set HEAD of target remote %s for '%s': it isn't local and no provider is configured", rs.TargetRemote.Name, rs.Path)
+		}
+	}
+
+	if rs.TargetDefaultBranch != "" {
+		_, exists := targetHashes[plumbing.NewBranchReferenceName(rs.TargetDefaultBranch)]
+		for _, b := range repoResult.Branches {
+			exists = exists || (b.Skipped == "" && b.Target == rs.TargetDefaultBranch)
+		}
+		if !exists {
+			logger.Warnf("Not setting HEAD of target remote %s for '%s' to %s, target doesn't have the branch", rs.TargetRemote.Name, rs.Path, rs.TargetDefaultBranch)
+		} else {
+			ok, err := setRemoteHead(opts.ctx, targetRemote, rs.TargetDefaultBranch, rs.Provider)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to set HEAD of target remote %s for '%s' to %s: %w", rs.TargetRemote.Name, rs.Path, rs.TargetDefaultBranch, err))
+			}
+			if ok {
+				logger.Infof("Set HEAD of target remote %s for '%s' to %s", rs.TargetRemote.Name, rs.Path, rs.TargetDefaultBranch)
+			} else {
+				logger.Warnf("Can't set HEAD of target remote %s for '%s': it isn't local and no provider is configured", rs.TargetRemote.Name, rs.Path)
+			}
+		}
+	}
+
+	if len(extraPushRefSpecs) > 0 {
+		logger.Infof("Pushing refs %v to %s", extraPushRefSpecs, rs.TargetRemote.Name)
+		err = targetOpts.run("push refs", func(ctx context.Context) error {
+			return repo.PushContext(ctx, &git.PushOptions{
+				RemoteName: rs.TargetRemote.Name,
+				RefSpecs:   extraPushRefSpecs,
+				Force:      true,
+				Auth:       targetAuth,
+			})
+		})
+		if err != nil {
+			if err == git.NoErrAlreadyUpToDate {
+				run.logUpToDate(logger, "refs already up to date")
+			} else {
+				err = signedPushHint(err, targetRemote, targetAuth, opts)
+				return repoResult, syncError(rs, fmt.Errorf("failed to push refs: %w", err))
+			}
+		}
+	} else if len(rs.extraRefs()) > 0 {
+		logger.Infof("No notes or extra refs to sync in %s", rs.Path)
+	}
+
+	// Push all tags
+	tags, err := repo.Tags()
+	if !rs.tagsEnabled() {
+		logger.Infof("Skipping tags of %s", rs.Path)
+	} else if err != nil {
+		return repoResult, syncError(rs, fmt.Errorf("failed to get tags: %w", err))
+	} else {
+		var listed []*plumbing.Reference
+		err = tags.ForEach(func(t *plumbing.Reference) error {
+			listed = append(listed, t)
+			return nil
+		})
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to get tags: %w", err))
+		}
+
+		pushTag := func(t *plumbing.Reference) error {
+			// Local tags missing in source (e.g. fetched from target) would be pushed back right before pruning.
+			if run.pruneTags && !sourceTags[t.Name()] {
+				return nil
+			}
+			if rs.TagsSince > 0 {
+				when, err := tagDate(repo, t)
+				if err != nil {
+					logger.Warnf("Skipping tag %s, failed to determine its date: %v", t.Name().Short(), err)
+					return nil
+				}
+				if time.Since(when) > time.Duration(rs.TagsSince) {
+					logger.Infof("Skipping tag %s from %s, older than %s", t.Name().Short(), when.Format(time.RFC3339), rs.TagsSince)
+					return nil
+				}
+			}
+
+			// Target already has the tag pointing at the same object (tag object for annotated ones), nothing to push.
+			if h, ok := targetHashes[rs.targetRef(t.Name())]; ok && h == t.Hash() {
+				logger.Debugf("tag %s unchanged on target, not pushing it", t.Name().Short())
+				repoResult.Tags = append(repoResult.Tags, &TagResult{Tag: t.Name().Short(), Hash: t.Hash().String(), Outcome: outcomeUpToDate})
+				return nil
+			}
+
+			if !run.allowLargePush {
+				if err := rs.checkPushSize(pushes, t.Name()); err != nil {
+					return err
+				}
+			}
+			tagsRefSpec := fmt.Sprintf("+%s:%s", t.Name(), rs.targetRef(t.Name()))
+			logger.Infof("Pushing tag %s to %s with refspec %s", t.Name().Short(), rs.TargetRemote.Name, tagsRefSpec)
+			err = targetOpts.run(fmt.Sprintf("push tag %s", t.Name().Short()), func(ctx context.Context) error {
+				return repo.PushContext(ctx, &git.PushOptions{
+					RemoteName: rs.TargetRemote.Name,
+					RefSpecs:   []config.RefSpec{config.RefSpec(tagsRefSpec)},
+					// go-git resolves followed tags only under refs/tags/, failing for namespaced ones.
+					FollowTags: rs.Namespace == "",
+					Force:      true,
+					Auth:       targetAuth,
+				})
+			})
+			tagResult := &TagResult{Tag: t.Name().Short(), Hash: t.Hash().String(), Outcome: outcomeUpdated}
+			if previous, ok := targetHashes[rs.targetRef(t.Name())]; ok {
+				tagResult.OldHash = previous.String()
+			}
+			if err != nil {
+				if err == git.NoErrAlreadyUpToDate {
+					run.logUpToDate(logger, "tag %s already up to date", t.Name().Short())
+					tagResult.Outcome = outcomeUpToDate
+				} else {
+					return fmt.Errorf("failed to push tags: %w", signedPushHint(err, targetRemote, targetAuth, opts))
+				}
+			} else {
+				logger.Infof("tag %s updated", t.Name().Short())
+			}
+			if err := pushes.pushed(t.Hash()); err != nil {
+				return fmt.Errorf("failed to walk pushed tag %s: %w", t.Name().Short(), err)
+			}
+			repoResult.Tags = append(repoResult.Tags, tagResult)
+
+			return nil
+		}
+		var failedTags []string
+		for _, t := range orderTags(listed, rs.TagOrder, rs.LatestTags) {
+			err := pushTag(t)
+			if err != nil && !rs.ContinueOnTagError {
+				return repoResult, syncError(rs, err)
+			}
+			if err != nil {
+				logger.Errorf("tag %s: %v", t.Name().Short(), err)
+				repoResult.Tags = append(repoResult.Tags, &TagResult{Tag: t.Name().Short(), Hash: t.Hash().String(), Outcome: outcomeFailed, Error: err.Error()})
+				failedTags = append(failedTags, t.Name().Short())
+			}
+		}
+
+		if run.pruneTags {
+			if err := pruneTags(repo, rs, targetHashes, sourceTags, targetAuth, targetOpts, repoResult); err != nil {
+				return repoResult, syncError(rs, err)
+			}
+		}
+		if len(failedTags) > 0 {
+			return repoResult, syncError(rs, fmt.Errorf("failed to push %d tags: %s", len(failedTags), strings.Join(failedTags, ", ")))
+		}
+	}
+
+	if len(rs.TargetHooks) > 0 {
+		ok, err := copyTargetHooks(targetRemote, rs.TargetHooks, logger)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to copy hooks to target remote %s for '%s': %w", rs.TargetRemote.Name, rs.Path, err))
+		}
+		if !ok {
+			logger.Warnf("Can't copy hooks to target remote %s for '%s': it isn't a local repository", rs.TargetRemote.Name, rs.Path)
+		}
+	}
+
+	if rs.VerifyPush {
+		if err := verifyPushed(targetRemote, rs, targetAuth, opts, repoResult); err != nil {
+			return repoResult, syncError(rs, err)
+		}
+		logger.Infof("Verified %d branches and %d tags on target remote %s", len(repoResult.Branches), len(repoResult.Tags), rs.TargetRemote.Name)
+	}
+
+	if rs.Provider != nil {
+		sourceRemote, err := repo.Remote(rs.SourceRemote.Name)
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to get source remote %s of %s: %w", rs.SourceRemote.Name, rs.Path, err))
+		}
+		logger.Infof("Mirroring metadata of %s via %s API", rs.Path, rs.Provider.Type)
+		err = mirrorMetadata(opts.ctx, rs.Provider, sourceRemote.Config().URLs[0], targetRemote.Config().URLs[0])
+		if err != nil {
+			return repoResult, syncError(rs, fmt.Errorf("failed to mirror metadata of %s: %w", rs.Path, err))
+		}
+
+		if rs.Provider.Releases {
+			// Tags the target has now: ones it had, pushed ones, minus pruned ones.
+			targetTags := map[string]bool{}
+			for name := range targetHashes {
+				if name.IsTag() {
+					targetTags[name.Short()] = true
+				}
+			}
+			for _, t := range repoResult.Tags {
+				switch t.Outcome {
+				case outcomeUpdated, outcomeUpToDate:
+					targetTags[t.Tag] = true
+				case outcomeDeleted:
+					delete(targetTags, t.Tag)
+				}
+			}
+			logger.Infof("Mirroring releases of %s via %s API", rs.Path, rs.Provider.Type)
+			err = mirrorReleases(opts.ctx, rs.Provider, sourceRemote.Config().URLs[0], targetRemote.Config().URLs[0], targetTags, logger)
+			if err != nil {
+				return repoResult, syncError(rs, fmt.Errorf("failed to mirror releases of %s: %w", rs.Path, err))
+			}
+		}
+	}
+
+	if rs.AfterPush != nil {
+		if err := sendAfterPush(opts.ctx, rs, repoResult, logger); err != nil && rs.AfterPush.Required {
+			return repoResult, syncError(rs, err)
+		}
+	}
+
+	if rs.TagTriggered {
+		run.state.setKnownTags(rs.Name, sourceTagNames)
+	}
+	if run.skipUnchangedHead && !sourceHeadHash.IsZero() {
+		run.state.setSourceHead(rs.Name, sourceHeadHash)
+	}
+
+	return repoResult, nil
+}
+
+// singleSourceBranch - Name of the only source branch in scope of the sync (source HEAD branch with defaultBranchOnly,
+// or sole literal --only-branches entry) when the source has it, so just that one can be fetched. Empty when more
+// branches may be in scope.
+func singleSourceBranch(remote *git.Remote, rs *Repo, run *runOptions, auth transport.AuthMethod, opts remoteOpts) (string, error) {
+	var branch string
+	switch {
+	case rs.DefaultBranchOnly:
+	case len(run.onlyBranches) == 1 && !strings.ContainsAny(run.onlyBranches[0], `*?[\`):
+		branch = run.onlyBranches[0]
+	default:
+		return "", nil
+	}
+
+	remoteRefs, err := listRemoteRefs(remote, auth, opts)
+	if err != nil {
+		return "", err
+	}
+	if rs.DefaultBranchOnly {
+		branch = rs.sourceDefaultBranch(remoteRefs).Short()
+	}
+	for _, r := range remoteRefs {
+		if name, ok := rs.sourceBranch(r.Name()); ok && branch != "" && name.Short() == branch {
+			return branch, nil
+		}
+	}
+
+	return "", nil
+}
+
+// verifyPushed - Check that branches and tags of the result point at their synced hashes on the target (deleted tags
+// are gone), listing target refs anew. All discrepancies are reported together.
+func verifyPushed(remote *git.Remote, rs *Repo, auth transport.AuthMethod, opts remoteOpts, result *RepoResult) error {
+	refs, err := listRemoteRefs(remote, auth, opts)
+	if err != nil {
+		return fmt.Errorf("failed to list refs of target remote %s to verify push: %w", rs.TargetRemote.Name, err)
+	}
+	hashes := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, r := range refs {
+		hashes[r.Name()] = r.Hash()
+	}
+
+	var problems []string
+	check := func(name plumbing.ReferenceName, expected string, deleted bool) {
+		actual, ok := hashes[name]
+		switch {
+		case deleted && ok:
+			problems = append(problems, fmt.Sprintf("%s still exists", name))
+		case !deleted && !ok:
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+		case !deleted && actual.String() != expected:
+			problems = append(problems, fmt.Sprintf("%s points at %s instead of %s", name, actual, expected))
+		}
+	}
+	for _, b := range result.Branches {
+		if b.Skipped == "" {
+			check(rs.targetRef(plumbing.NewBranchReferenceName(b.Target)), b.Hash, false)
+		}
+	}
+	for _, t := range result.Tags {
+		check(rs.targetRef(plumbing.NewTagReferenceName(t.Tag)), t.Hash, t.Outcome == outcomeDeleted)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("push to %s not in effect: %s", rs.TargetRemote.Name, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// pruneTags - Delete tags the target has but the source doesn't, recording them in the result.
+func pruneTags(repo *git.Repository, rs *Repo, targetHashes map[plumbing.ReferenceName]plumbing.Hash,
+	sourceTags map[plumbing.ReferenceName]bool, auth transport.AuthMethod, opts remoteOpts, repoResult *RepoResult) error {
+	var orphans []string
+	for name := range targetHashes {
+		if sourceName, ok := rs.sourceRef(name); ok && sourceName.IsTag() && !sourceTags[sourceName] {
+			orphans = append(orphans, name.String())
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	sort.Strings(orphans)
+
+	var refSpecs []config.RefSpec
+	for _, name := range orphans {
+		refSpecs = append(refSpecs, config.RefSpec(":"+name))
+	}
+	opts.log.Infof("Deleting tags %v missing in source from %s", orphans, rs.TargetRemote.Name)
+	err := opts.run("delete orphan tags", func(ctx context.Context) error {
+		return repo.PushContext(ctx, &git.PushOptions{
+			RemoteName: rs.TargetRemote.Name,
+			RefSpecs:   refSpecs,
+			Auth:       auth,
+		})
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("failed to delete orphan tags: %w", err)
+	}
+
+	for _, name := range orphans {
+		ref := plumbing.ReferenceName(name)
+		sourceName, _ := rs.sourceRef(ref)
+		repoResult.Tags = append(repoResult.Tags, &TagResult{
+			Tag:     sourceName.Short(),
+			Hash:    targetHashes[ref].String(),
+			Outcome: outcomeDeleted,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// TestMirror - Sync seeded source into an empty target, expecting mapped branches and all tags.
+func TestMirror(t *testing.T) {
+	source, target := seedSource(t), emptyTarget(t)
+	rs := &Repo{
+		Name:         "mirror",
+		SourceRemote: &Remote{Name: "origin", Url: addMemRemote(t, "mirror-source", source.Storer, "")},
+		TargetRemote: &Remote{Name: "mirror", Url: addMemRemote(t, "mirror-target", target.Storer, "")},
+	}
+	repoSync := &RepoSync{
+		Repos:         map[string]*Repo{rs.Name: rs},
+		BranchMapping: map[string]string{"master": "main"},
+	}
+	if err := repoSync.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncRepo(repoSync, rs, testRun(), testLogger()); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRefs(t, target, map[plumbing.ReferenceName]plumbing.Hash{
+		plumbing.NewBranchReferenceName("main"):    refHash(source, plumbing.NewBranchReferenceName("master")),
+		plumbing.NewBranchReferenceName("develop"): refHash(source, plumbing.NewBranchReferenceName("develop")),
+		plumbing.NewTagReferenceName("v1.0.0"):     refHash(source, plumbing.NewTagReferenceName("v1.0.0")),
+		plumbing.NewTagReferenceName("v1.1.0"):     refHash(source, plumbing.NewTagReferenceName("v1.1.0")),
+	})
+}
+
+// TestSplitCredentials - Sync between remotes requiring different credentials, read token for the source and write
+// token for the target, expecting each remote to get its own.
+func TestSplitCredentials(t *testing.T) {
+	source, target := seedSource(t), emptyTarget(t)
+	rs := &Repo{
+		Name:         "split",
+		SourceRemote: &Remote{Name: "origin", Url: addMemRemote(t, "split-source", source.Storer, "reader:read-token"), Username: "reader", token: "read-token"},
+		TargetRemote: &Remote{Name: "mirror", Url: addMemRemote(t, "split-target", target.Storer, "writer:write-token"), Username: "writer", token: "write-token"},
+	}
+	repoSync := &RepoSync{Repos: map[string]*Repo{rs.Name: rs}}
+	if err := repoSync.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncRepo(repoSync, rs, testRun(), testLogger()); err != nil {
+		t.Fatal(err)
+	}
+	expectRefs(t, target, map[plumbing.ReferenceName]plumbing.Hash{
+		plumbing.NewBranchReferenceName("master"):  refHash(source, plumbing.NewBranchReferenceName("master")),
+		plumbing.NewBranchReferenceName("develop"): refHash(source, plumbing.NewBranchReferenceName("develop")),
+		plumbing.NewTagReferenceName("v1.0.0"):     refHash(source, plumbing.NewTagReferenceName("v1.0.0")),
+		plumbing.NewTagReferenceName("v1.1.0"):     refHash(source, plumbing.NewTagReferenceName("v1.1.0")),
+	})
+}
+
+// TestSplitCredentialsReadTokenRejected - Source's read token, used for the target readable with it, must not be
+// accepted for pushing: fetching from the source and listing the target succeed, the push fails authentication.
+func TestSplitCredentialsReadTokenRejected(t *testing.T) {
+	source, target := seedSource(t), emptyTarget(t)
+	rs := &Repo{
+		Name:         "split-read-token",
+		SourceRemote: &Remote{Name: "origin", Url: addMemRemote(t, "split-read-source", source.Storer, "reader:read-token"), Username: "reader", token: "read-token"},
+		TargetRemote: &Remote{Name: "mirror", Url: addMemRemote(t, "split-read-target", target.Storer, "reader:read-token"), Username: "reader", token: "read-token"},
+	}
+	requirePushCredentials("split-read-target", "writer:write-token")
+	repoSync := &RepoSync{Repos: map[string]*Repo{rs.Name: rs}}
+	if err := repoSync.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := syncRepo(repoSync, rs, testRun(), testLogger())
+	if !errors.Is(err, ErrAuth) {
+		t.Fatalf("expected %v, got %v", ErrAuth, err)
+	}
+	if !strings.Contains(err.Error(), "failed to push") {
+		t.Errorf("expected push to fail, got %v", err)
+	}
+	expectRefs(t, target, map[plumbing.ReferenceName]plumbing.Hash{})
+}
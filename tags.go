@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Orders tags are pushed in, see Repo.TagOrder.
+const (
+	tagOrderSemver  = "semver"
+	tagOrderLexical = "lexical"
+)
+
+// semver - parsed semantic version of a tag name.
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+// parseSemver - Parse tag name as semantic version, with optional `v` prefix, minor and patch (missing ones are 0).
+// Build metadata is ignored. Returns false for names that aren't versions.
+func parseSemver(name string) (semver, bool) {
+	var v semver
+	name = strings.TrimPrefix(name, "v")
+	if i := strings.IndexByte(name, '+'); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.IndexByte(name, '-'); i >= 0 {
+		v.prerelease = strings.Split(name[i+1:], ".")
+		name = name[:i]
+	}
+
+	parts := strings.Split(name, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.core[i] = n
+	}
+	return v, true
+}
+
+// compare - Compare to other version by semver precedence: -1 when lower, 0 when same, 1 when higher.
+func (v semver) compare(other semver) int {
+	for i := range v.core {
+		if v.core[i] != other.core[i] {
+			return cmpInt(v.core[i], other.core[i])
+		}
+	}
+
+	// Release has higher precedence than its prereleases.
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		an, aErr := strconv.Atoi(a)
+		bn, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			return cmpInt(an, bn)
+		case aErr == nil:
+			// Numeric identifiers are lower than alphanumeric ones.
+			return -1
+		case bErr == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return cmpInt(len(v.prerelease), len(other.prerelease))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// orderTags - Sort tags in the order, semver ones by precedence followed by the rest lexically, otherwise all
+// lexically. Tags named in last are moved to the end in the given order, e.g. moving `latest`
+// pushed after the versions it points at.
+func orderTags(tags []*plumbing.Reference, order string, last []string) []*plumbing.Reference {
+	sorted := append([]*plumbing.Reference{}, tags...)
+	switch order {
+	case tagOrderSemver:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := sorted[i].Name().Short(), sorted[j].Name().Short()
+			av, aOk := parseSemver(a)
+			bv, bOk := parseSemver(b)
+			switch {
+			case aOk && bOk:
+				if c := av.compare(bv); c != 0 {
+					return c < 0
+				}
+				return a < b
+			case aOk != bOk:
+				return aOk
+			}
+			return a < b
+		})
+	default:
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name().Short() < sorted[j].Name().Short() })
+	}
+
+	if len(last) == 0 {
+		return sorted
+	}
+	rank := map[string]int{}
+	for i, name := range last {
+		rank[name] = i + 1
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank[sorted[i].Name().Short()] < rank[sorted[j].Name().Short()]
+	})
+	return sorted
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestParseSemver(t *testing.T) {
+	cases := []struct {
+		name       string
+		ok         bool
+		core       [3]int
+		prerelease []string
+	}{
+		{"1.2.3", true, [3]int{1, 2, 3}, nil},
+		{"v1.2.3", true, [3]int{1, 2, 3}, nil},
+		{"v2", true, [3]int{2, 0, 0}, nil},
+		{"v2.1", true, [3]int{2, 1, 0}, nil},
+		{"v1.0.0-rc.1", true, [3]int{1, 0, 0}, []string{"rc", "1"}},
+		{"v1.0.0+build.5", true, [3]int{1, 0, 0}, nil},
+		{"v1.0.0-beta+build.5", true, [3]int{1, 0, 0}, []string{"beta"}},
+		{"latest", false, [3]int{}, nil},
+		{"v", false, [3]int{}, nil},
+		{"release-1.0", false, [3]int{}, nil},
+		{"v1.2.3.4", false, [3]int{}, nil},
+		{"v1..3", false, [3]int{}, nil},
+		{"vv1.0.0", false, [3]int{}, nil},
+	}
+
+	for _, c := range cases {
+		v, ok := parseSemver(c.name)
+		if ok != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.name, c.ok, ok)
+			continue
+		}
+		if ok && (v.core != c.core || !reflect.DeepEqual(v.prerelease, c.prerelease)) {
+			t.Errorf("%s: expected %v-%v, got %v-%v", c.name, c.core, c.prerelease, v.core, v.prerelease)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.0.0", "1.0.0", 0},
+		{"v1.0.0", "v1.0.1", -1},
+		{"v1.10.0", "v1.9.0", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0", "v1.0.0-rc.1", 1},
+		{"v1.0.0-rc.2", "v1.0.0-rc.10", -1},
+		{"v1.0.0-1", "v1.0.0-alpha", -1},
+		{"v1.0.0-alpha", "v1.0.0-beta", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0+a", "v1.0.0+b", 0},
+	}
+
+	for _, c := range cases {
+		a, _ := parseSemver(c.a)
+		b, _ := parseSemver(c.b)
+		if got := a.compare(b); got != c.want {
+			t.Errorf("%s vs %s: expected %d, got %d", c.a, c.b, c.want, got)
+		}
+	}
+}
+
+func TestOrderTags(t *testing.T) {
+	tags := func(names ...string) []*plumbing.Reference {
+		var refs []*plumbing.Reference
+		for _, n := range names {
+			refs = append(refs, plumbing.NewHashReference(plumbing.NewTagReferenceName(n), plumbing.ZeroHash))
+		}
+		return refs
+	}
+	listed := tags("v1.10.0", "latest", "v1.2.0", "nightly", "v1.2.0-rc.1", "1.3.0", "stable", "v0.9")
+
+	cases := []struct {
+		order string
+		last  []string
+		want  []string
+	}{
+		{tagOrderSemver, nil, []string{"v0.9", "v1.2.0-rc.1", "v1.2.0", "1.3.0", "v1.10.0", "latest", "nightly", "stable"}},
+		{tagOrderSemver, []string{"stable", "latest"}, []string{"v0.9", "v1.2.0-rc.1", "v1.2.0", "1.3.0", "v1.10.0", "nightly", "stable", "latest"}},
+		{tagOrderLexical, nil, []string{"1.3.0", "latest", "nightly", "stable", "v0.9", "v1.10.0", "v1.2.0", "v1.2.0-rc.1"}},
+		{tagOrderLexical, []string{"latest", "missing"}, []string{"1.3.0", "nightly", "stable", "v0.9", "v1.10.0", "v1.2.0", "v1.2.0-rc.1", "latest"}},
+	}
+
+	for _, c := range cases {
+		var got []string
+		for _, r := range orderTags(listed, c.order, c.last) {
+			got = append(got, r.Name().Short())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s with last %v: expected %v, got %v", c.order, c.last, c.want, got)
+		}
+	}
+}
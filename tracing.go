@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer - Tracer of sync operations. Until setupTracing installs an exporting provider, the global no-op one is used
+// and spans cost next to nothing.
+var tracer = otel.Tracer("github.com/incanetworks/go-repo-sync")
+
+// setupTracing - Export spans via OTLP over HTTP, configured by standard OTEL_EXPORTER_OTLP_* env variables. Returns
+// function flushing pending spans, to be called before exiting.
+func setupTracing(ctx context.Context) (func(), error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("go-repo-sync"),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("github.com/incanetworks/go-repo-sync")
+
+	return func() {
+		// Run context may be canceled by now, flush regardless.
+		_ = provider.Shutdown(context.Background())
+	}, nil
+}
+
+// startRemoteSpan - Start span of a remote operation described by what, e.g. `fetch origin`. Span is named by the
+// kind of the operation (its first word) to keep names low-cardinality, the full description is an attribute.
+func startRemoteSpan(ctx context.Context, what string) (context.Context, trace.Span) {
+	kind := what
+	if i := strings.IndexByte(what, ' '); i > 0 {
+		kind = what[:i]
+	}
+
+	return tracer.Start(ctx, kind, trace.WithAttributes(attribute.String("operation", what)))
+}
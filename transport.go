@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// userAgent - User-Agent of all HTTP(S) requests of the tool, git transport and provider APIs alike.
+var userAgent = "go-repo-sync/" + version
+
+// userAgentTransport - http.RoundTripper setting User-Agent header of every request.
+type userAgentTransport struct {
+	next http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+
+	return t.next.RoundTrip(req)
+}
+
+// installTransport - Set up go-git HTTP(S) transport to identify itself with given user agent (default one when
+// empty). The agent is also appended to the agent capability go-git advertises over any transport.
+func installTransport(agent string) error {
+	if agent != "" {
+		userAgent = agent
+	}
+	if err := os.Setenv("GO_GIT_USER_AGENT_EXTRA", userAgent); err != nil {
+		return err
+	}
+
+	httpClient := githttp.NewClient(&http.Client{
+		Transport: &userAgentTransport{next: &traceTransport{next: http.DefaultTransport}},
+	})
+	client.InstallProtocol("http", httpClient)
+	client.InstallProtocol("https", httpClient)
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseDuration - Parse duration of config options and flags alike, in time.ParseDuration format, e.g. `90s`, `15m`
+// or `1h30m`.
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s', expected e.g. 90s, 15m or 1h30m", s)
+	}
+	return d, nil
+}
+
+// byteSizePattern - number of bytes with optional fraction and unit, e.g. `500MB` or `1.5 GiB`.
+var byteSizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
+
+// byteSizeUnits - multipliers of size units, lowercase: decimal kB, MB, ... and binary KiB, MiB, ..., with single
+// letters k, m, g and t binary like in git config.
+var byteSizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"k": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tib": 1 << 40,
+}
+
+// parseByteSize - Parse size of config options, a number of bytes with optional unit, e.g. `1048576`, `500MB` or
+// `1.5GiB`.
+func parseByteSize(s string) (int64, error) {
+	m := byteSizePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size '%s', expected bytes with optional unit, e.g. 1048576, 500MB or 1.5GiB", s)
+	}
+	unit, ok := byteSizeUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size '%s': unknown unit '%s', use B, kB, MB, GB, TB, KiB, MiB, GiB or TiB", s, m[2])
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %v", s, err)
+	}
+	bytes := math.Round(n * unit)
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size '%s': too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// duration - time.Duration of config options, parsed by parseDuration.
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := parseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set - Parse flag value, see durationFlag.
+func (d *duration) Set(s string) error {
+	parsed, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// durationFlag - Define duration flag parsed by parseDuration, like flag.Duration.
+func durationFlag(name string, value time.Duration, usage string) *time.Duration {
+	p := &value
+	flag.Var((*duration)(p), name, usage)
+	return p
+}
+
+// byteSize - number of bytes of config options, parsed by parseByteSize.
+type byteSize int64
+
+func (b *byteSize) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := parseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*b = byteSize(parsed)
+	return nil
+}
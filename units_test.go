@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"90s", 90 * time.Second, false},
+		{"15m", 15 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{" 2h ", 2 * time.Hour, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"0", 0, false},
+		{"1.5h", 90 * time.Minute, false},
+		{"", 0, true},
+		{"15", 0, true},
+		{"15M", 0, true},
+		{"1d", 0, true},
+		{"fast", 0, true},
+		{"3000000h", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseDuration(c.value)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("'%s': expected error, got %s", c.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s': %v", c.value, err)
+		} else if got != c.want {
+			t.Errorf("'%s': expected %s, got %s", c.value, c.want, got)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"1048576", 1048576, false},
+		{"0", 0, false},
+		{"100B", 100, false},
+		{"500MB", 500e6, false},
+		{"500mb", 500e6, false},
+		{"2kB", 2000, false},
+		{"1.5 GiB", 3 << 29, false},
+		{"1.5gib", 3 << 29, false},
+		{"4k", 4 << 10, false},
+		{"4K", 4 << 10, false},
+		{"2m", 2 << 20, false},
+		{"1t", 1 << 40, false},
+		{"1TB", 1e12, false},
+		{" 10 MiB ", 10 << 20, false},
+		{"0.5", 1, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"1.MB", 0, true},
+		{"1e3", 0, true},
+		{"10 PB", 0, true},
+		{"10 bytes", 0, true},
+		{"8388608TiB", 0, true},
+		{"9223372036854775808", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseByteSize(c.value)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("'%s': expected error, got %d", c.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s': %v", c.value, err)
+		} else if got != c.want {
+			t.Errorf("'%s': expected %d, got %d", c.value, c.want, got)
+		}
+	}
+}
+
+func TestUnitsUnmarshalYAML(t *testing.T) {
+	var v struct {
+		Timeout duration `yaml:"timeout"`
+		Limit   byteSize `yaml:"limit"`
+	}
+	if err := yaml.Unmarshal([]byte("timeout: 1h30m\nlimit: 1.5GiB\n"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(v.Timeout) != 90*time.Minute || v.Limit != 3<<29 {
+		t.Errorf("expected 1h30m and %d, got %s and %d", 3<<29, v.Timeout, v.Limit)
+	}
+
+	if err := yaml.Unmarshal([]byte("timeout: 1h\nlimit: lots\n"), &v); err == nil {
+		t.Errorf("expected error for invalid size")
+	}
+}
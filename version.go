@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+// Build metadata, set at build time via:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// versionString - Return human readable build metadata of the binary.
+func versionString() string {
+	return fmt.Sprintf("go-repo-sync %s (commit %s, built %s)", version, commit, date)
+}
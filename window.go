@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hoursWindow - daily window of time of day, e.g. 02:00-05:00, in given location. End before start wraps over
+// midnight. Bounds are minutes of the wall clock day, so days with DST changes still open at the configured time.
+type hoursWindow struct {
+	start, end int
+	loc        *time.Location
+}
+
+// parseHoursWindow - Parse window like `02:00-05:00`, optionally followed by IANA timezone name, e.g.
+// `22:00-04:00 Europe/Bratislava`. Local timezone is used when it's missing.
+func parseHoursWindow(s string) (*hoursWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid window '%s', expected HH:MM-HH:MM [timezone]", s)
+	}
+
+	w := &hoursWindow{loc: time.Local}
+	if len(fields) == 2 {
+		loc, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone of window '%s': %v", s, err)
+		}
+		w.loc = loc
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid window '%s', expected HH:MM-HH:MM [timezone]", s)
+	}
+	for i, b := range bounds {
+		t, err := time.Parse("15:04", b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time '%s' of window '%s'", b, s)
+		}
+		offset := t.Hour()*60 + t.Minute()
+		if i == 0 {
+			w.start = offset
+		} else {
+			w.end = offset
+		}
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("empty window '%s'", s)
+	}
+
+	return w, nil
+}
+
+// minuteOfDay - Wall clock time of day of t in the window's location, in minutes.
+func (w *hoursWindow) minuteOfDay(t time.Time) int {
+	t = t.In(w.loc)
+	return t.Hour()*60 + t.Minute()
+}
+
+// contains - Whether t falls into the window.
+func (w *hoursWindow) contains(t time.Time) bool {
+	offset := w.minuteOfDay(t)
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+
+	return offset >= w.start || offset < w.end
+}
+
+// nextStart - Next time the window opens after t.
+func (w *hoursWindow) nextStart(t time.Time) time.Time {
+	t = t.In(w.loc)
+	day := t.Day()
+	if w.minuteOfDay(t) >= w.start {
+		day++
+	}
+
+	return time.Date(t.Year(), t.Month(), day, w.start/60, w.start%60, 0, 0, w.loc)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHoursWindow(t *testing.T) {
+	cases := []struct {
+		window string
+		valid  bool
+	}{
+		{"02:00-05:00", true},
+		{"22:00-04:00", true},
+		{"22:00-04:00 Europe/Bratislava", true},
+		{"", false},
+		{"02:00", false},
+		{"02:00-05:00-06:00", false},
+		{"2am-5am", false},
+		{"25:00-05:00", false},
+		{"02:00-02:00", false},
+		{"02:00-05:00 Nowhere/Nothing", false},
+		{"02:00-05:00 UTC extra", false},
+	}
+
+	for _, c := range cases {
+		_, err := parseHoursWindow(c.window)
+		if c.valid && err != nil {
+			t.Errorf("expected '%s' to be valid, got %v", c.window, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("expected '%s' to be invalid", c.window)
+		}
+	}
+}
+
+func TestHoursWindow(t *testing.T) {
+	bratislava, err := time.LoadLocation("Europe/Bratislava")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, bratislava)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	cases := []struct {
+		name     string
+		window   string
+		now      time.Time
+		contains bool
+		next     time.Time
+	}{
+		{"before window", "02:00-05:00 Europe/Bratislava", at("2023-06-01 01:59"), false, at("2023-06-01 02:00")},
+		{"in window", "02:00-05:00 Europe/Bratislava", at("2023-06-01 02:00"), true, at("2023-06-02 02:00")},
+		{"window end", "02:00-05:00 Europe/Bratislava", at("2023-06-01 05:00"), false, at("2023-06-02 02:00")},
+		{"wrapped before midnight", "22:00-04:00 Europe/Bratislava", at("2023-06-01 23:30"), true, at("2023-06-02 22:00")},
+		{"wrapped after midnight", "22:00-04:00 Europe/Bratislava", at("2023-06-02 03:59"), true, at("2023-06-02 22:00")},
+		{"wrapped outside", "22:00-04:00 Europe/Bratislava", at("2023-06-02 04:00"), false, at("2023-06-02 22:00")},
+		{"other timezone", "02:00-05:00 UTC", at("2023-06-01 06:30"), true, at("2023-06-02 04:00")},
+		{"last day of month", "02:00-05:00 Europe/Bratislava", at("2023-06-30 12:00"), false, at("2023-07-01 02:00")},
+		// DST starts at 02:00 on 2023-03-26 and ends at 03:00 on 2023-10-29.
+		{"after spring forward", "04:00-05:00 Europe/Bratislava", at("2023-03-26 04:30"), true, at("2023-03-27 04:00")},
+		{"over spring forward", "01:00-05:00 Europe/Bratislava", at("2023-03-25 12:00"), false, at("2023-03-26 01:00")},
+		{"opening after spring forward", "04:00-05:00 Europe/Bratislava", at("2023-03-26 00:30"), false, at("2023-03-26 04:00")},
+		{"opening after fall back", "04:00-05:00 Europe/Bratislava", at("2023-10-29 00:30"), false, at("2023-10-29 04:00")},
+		{"after fall back", "04:00-05:00 Europe/Bratislava", at("2023-10-29 04:30"), true, at("2023-10-30 04:00")},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w, err := parseHoursWindow(c.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.contains(c.now); got != c.contains {
+				t.Errorf("expected contains %v, got %v", c.contains, got)
+			}
+			if got := w.nextStart(c.now); !got.Equal(c.next) {
+				t.Errorf("expected next start %s, got %s", c.next, got)
+			}
+		})
+	}
+}